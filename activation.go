@@ -0,0 +1,140 @@
+package nn
+
+import (
+	"math"
+
+	"nn/tensor"
+)
+
+// Activation 激活函数
+type Activation interface {
+	Forward(x float64) float64       // 激活
+	Derivative(x, y float64) float64 // 导数，x为激活前的值，y为激活后的值
+}
+
+// Sigmoid 1/(1+e^-x)
+type Sigmoid struct{}
+
+// Forward ...
+func (Sigmoid) Forward(x float64) float64 { return 1 / (1 + math.Exp(-x)) }
+
+// Derivative ...
+func (Sigmoid) Derivative(x, y float64) float64 { return y * (1 - y) }
+
+// Tanh ...
+type Tanh struct{}
+
+// Forward ...
+func (Tanh) Forward(x float64) float64 { return math.Tanh(x) }
+
+// Derivative ...
+func (Tanh) Derivative(x, y float64) float64 { return 1 - y*y }
+
+// ReLU max(0,x)
+type ReLU struct{}
+
+// Forward ...
+func (ReLU) Forward(x float64) float64 {
+	if x > 0 {
+		return x
+	}
+	return 0
+}
+
+// Derivative ...
+func (ReLU) Derivative(x, y float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+// LeakyReLU x<0时为Alpha*x，Alpha默认0.01
+type LeakyReLU struct {
+	Alpha float64
+}
+
+func (o LeakyReLU) alpha() float64 {
+	if o.Alpha == 0 {
+		return 0.01
+	}
+	return o.Alpha
+}
+
+// Forward ...
+func (o LeakyReLU) Forward(x float64) float64 {
+	if x > 0 {
+		return x
+	}
+	return o.alpha() * x
+}
+
+// Derivative ...
+func (o LeakyReLU) Derivative(x, y float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return o.alpha()
+}
+
+// ELU x<0时为Alpha*(e^x-1)，Alpha默认1
+type ELU struct {
+	Alpha float64
+}
+
+func (o ELU) alpha() float64 {
+	if o.Alpha == 0 {
+		return 1
+	}
+	return o.Alpha
+}
+
+// Forward ...
+func (o ELU) Forward(x float64) float64 {
+	if x > 0 {
+		return x
+	}
+	return o.alpha() * math.Expm1(x)
+}
+
+// Derivative ...
+func (o ELU) Derivative(x, y float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return y + o.alpha()
+}
+
+// Softplus ln(1+e^x)
+type Softplus struct{}
+
+// Forward ...
+func (Softplus) Forward(x float64) float64 {
+	// 避免e^x溢出
+	return math.Max(x, 0) + math.Log1p(math.Exp(-math.Abs(x)))
+}
+
+// Derivative ...
+func (Softplus) Derivative(x, y float64) float64 { return 1 / (1 + math.Exp(-x)) }
+
+// Identity 线性输出，用于回归
+type Identity struct{}
+
+// Forward ...
+func (Identity) Forward(x float64) float64 { return x }
+
+// Derivative ...
+func (Identity) Derivative(x, y float64) float64 { return 1 }
+
+// softmax 减去最大值避免e^x溢出
+func softmax(z, y []Float) {
+	max := tensor.Max(z)
+	var sum Float
+	for k, v := range z {
+		y[k] = Float(math.Exp(float64(v - max)))
+		sum += y[k]
+	}
+	for k := range y {
+		y[k] /= sum
+	}
+}
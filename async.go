@@ -0,0 +1,103 @@
+package nn
+
+import (
+	"context"
+	mrand "math/rand"
+	"sync"
+)
+
+// 异步训练一轮（Hogwild）：Data分成Workers份，每个goroutine在自己的Workspace上计算梯度，
+// 每累计BatchSize个样本就不加锁直接用SGD修正共享的权重，goroutine之间的修正可能互相覆盖，
+// 所以结果不确定。适合稀疏的大样本集，每个goroutine的统计在stats.Workers中
+func (o *NN) trainAsync(ctx context.Context, learn float64, stats *TrainStats) error {
+	workers := o.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(o.Data) {
+		workers = len(o.Data)
+	}
+	if len(stats.Workers) != workers {
+		stats.Workers = make([]TrainStats, workers)
+	}
+
+	batch := o.batchSize()
+	counts, steps := make([]int, workers), make([]int, workers) // 这一轮每个goroutine的样本数和修正次数
+	wg := sync.WaitGroup{}
+	for k := 0; k < workers; k++ {
+		o.worker(k)
+	}
+	for k := 0; k < workers; k++ {
+		// 每个goroutine的样本，打乱顺序的随机数由NN的随机数生成
+		start, end := k*len(o.Data)/workers, (k+1)*len(o.Data)/workers
+		shard := make([]int, end-start)
+		for i := range shard {
+			shard[i] = start + i
+		}
+		if o.Shuffle {
+			rnd := mrand.New(&randSource{state: o.rand().Uint64()})
+			rnd.Shuffle(len(shard), func(i, j int) {
+				shard[i], shard[j] = shard[j], shard[i]
+			})
+		}
+
+		wg.Add(1)
+		go func(ws *Workspace, shard []int, stats *TrainStats, count, step *int) {
+			defer wg.Done()
+			stats.MaxDiff, stats.Loss, stats.Learn = 0, 0, learn
+			sum, n := 0.0, 0
+			for i, index := range shard {
+				select {
+				case <-ctx.Done():
+					ws.grad.zero()
+					return
+				default:
+				}
+
+				v := &o.Data[index]
+				output := o.forward(v.Input, ws.z, ws.a)
+				o.backward(v.Input, v.Output, ws, ws.grad)
+				diff := o.diff(output, v.Output)
+				if diff > stats.MaxDiff {
+					stats.MaxDiff = diff
+				}
+				sum += diff
+				stats.Study++
+				stats.Loss = sum / float64(i+1)
+				*count++
+
+				n++
+				if n == batch || i == len(shard)-1 {
+					o.clip(ws.grad, n)
+					o.penalize(ws.grad, n)
+					params, grads := o.params(ws.grad)
+					SGD{}.Update(params, grads, learn/float64(n))
+					o.constrain()
+					ws.grad.zero()
+					n = 0
+					stats.Steps++
+					*step++
+				}
+			}
+			stats.Epoch++
+		}(o.workers[k], shard, &stats.Workers[k], &counts[k], &steps[k])
+	}
+	wg.Wait()
+
+	// 汇总
+	count, sum := 0, 0.0
+	stats.MaxDiff = 0
+	for k, v := range stats.Workers {
+		count += counts[k]
+		sum += v.Loss * float64(counts[k])
+		stats.Steps += steps[k]
+		if v.MaxDiff > stats.MaxDiff {
+			stats.MaxDiff = v.MaxDiff
+		}
+	}
+	stats.Study += count
+	if count > 0 {
+		stats.Loss = sum / float64(count)
+	}
+	return ctx.Err()
+}
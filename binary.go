@@ -0,0 +1,326 @@
+package nn
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// 二进制模型文件，整数和浮点数都是小端序：
+//
+//	magic "NNMB" | 版本 uint16 | 浮点数位数 uint8 | 头长度 uint32 | 头（JSON，没有Weight、Bias和Arrays中数组的Model）
+//	| 每层的权重，按行 | 每层的偏置 | Arrays中的数组，按行 | CRC-32（IEEE，之前的所有字节）
+//
+// 版本2起优化器的状态和检查点中最好的权重、偏置也写在偏置之后，版本1中它们在头中
+const binaryMagic = "NNMB"
+
+// BinaryVersion 二进制模型文件的版本，格式不兼容时增加
+const BinaryVersion = 2
+
+// 头的最大长度，防止错误的文件分配过多内存
+const maxBinaryHeader = 64 << 20
+
+// 二进制模型文件的头
+type binaryHeader struct {
+	Model
+	Arrays []binaryArray `json:",omitempty"`
+}
+
+// 不放在头中的数组
+type binaryArray struct {
+	Name string // "Optimizer."加字段名、"Train.BestWeight"或"Train.BestBias"
+	Rows []int  `json:",omitempty"` // 优化器状态每行的长度，最好的权重和偏置与Weight、Bias的形状相同
+}
+
+// ErrChecksum 二进制模型文件的校验和不正确
+var ErrChecksum = errors.New("model checksum mismatch")
+
+// WriteModelBinary 以二进制写入模型，比WriteModel小，读取更快
+func (o *NN) WriteModelBinary(w io.Writer) error {
+	return o.writeBinary(w, floatBits)
+}
+
+func (o *NN) writeBinary(w io.Writer, bits int) error {
+	m, err := o.model(true)
+	if err != nil {
+		return err
+	}
+	return writeBinary(w, m, bits)
+}
+
+// bits为浮点数的位数，32或64
+func writeBinary(w io.Writer, m *Model, bits int) error {
+	head := &binaryHeader{Model: *m}
+	head.Weight, head.Bias = nil, nil
+	var rows [][]Float // Arrays中的数组，按顺序写入
+
+	// 优化器的状态
+	names := make([]string, 0, len(m.state))
+	for k := range m.state {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		array := binaryArray{Name: "Optimizer." + k}
+		for _, row := range m.state[k] {
+			array.Rows = append(array.Rows, len(row))
+		}
+		head.Arrays = append(head.Arrays, array)
+		rows = append(rows, m.state[k]...)
+	}
+
+	// EarlyStop最好的权重和偏置
+	if t := m.Train; t != nil && (t.BestWeight != nil || t.BestBias != nil) {
+		if err := checkWeight(m.sizes(), t.BestWeight, t.BestBias); err != nil {
+			return fmt.Errorf("best weight: %w", err)
+		}
+		train := *t
+		train.BestWeight, train.BestBias = nil, nil
+		head.Train = &train
+		head.Arrays = append(head.Arrays, binaryArray{Name: "Train.BestWeight"})
+		for _, v := range t.BestWeight {
+			rows = append(rows, v...)
+		}
+		if t.BestBias != nil {
+			head.Arrays = append(head.Arrays, binaryArray{Name: "Train.BestBias"})
+			rows = append(rows, t.BestBias...)
+		}
+	}
+
+	header, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	if len(header) > maxBinaryHeader {
+		return fmt.Errorf("binary model header of %d bytes exceeds %d", len(header), maxBinaryHeader)
+	}
+
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	bw.WriteString(binaryMagic)
+	binary.Write(bw, binary.LittleEndian, uint16(BinaryVersion))
+	bw.WriteByte(byte(bits))
+	binary.Write(bw, binary.LittleEndian, uint32(len(header)))
+	bw.Write(header)
+	for _, v := range m.Weight {
+		for _, row := range v {
+			writeFloats(bw, row, bits)
+		}
+	}
+	for _, v := range m.Bias {
+		writeFloats(bw, v, bits)
+	}
+	for _, v := range rows {
+		writeFloats(bw, v, bits)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, crc.Sum32())
+}
+
+func writeFloats(w *bufio.Writer, values []Float, bits int) {
+	var buf [8]byte
+	for _, v := range values {
+		if bits == 32 {
+			binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(v)))
+			w.Write(buf[:4])
+		} else {
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(float64(v)))
+			w.Write(buf[:])
+		}
+	}
+}
+
+// ReadModelBinary 读取WriteModelBinary写入的模型，浮点数按当前精度转换
+func ReadModelBinary(r io.Reader) (*NN, error) {
+	m, err := readBinary(r)
+	if err != nil {
+		return nil, err
+	}
+	return m.NN()
+}
+
+func readBinary(r io.Reader) (*Model, error) {
+	crc := crc32.NewIEEE()
+	br := &binaryReader{r: io.TeeReader(r, crc)}
+
+	if magic := br.read(len(binaryMagic)); br.err == nil && string(magic) != binaryMagic {
+		return nil, errors.New("not a binary model file")
+	}
+	version := br.uintN(2)
+	bits := int(br.uintN(1))
+	size := br.uintN(4)
+	if br.err != nil {
+		return nil, br.error()
+	}
+	if version == 0 || version > BinaryVersion {
+		return nil, fmt.Errorf("binary model version %d is not supported", version)
+	}
+	if bits != 32 && bits != 64 {
+		return nil, fmt.Errorf("binary model has %d-bit floats", bits)
+	}
+	if size > maxBinaryHeader {
+		return nil, fmt.Errorf("binary model header of %d bytes", size)
+	}
+
+	head := &binaryHeader{}
+	header := br.read(int(size))
+	if br.err != nil {
+		return nil, br.error()
+	}
+	if err := json.Unmarshal(header, head); err != nil {
+		return nil, err
+	}
+	m := &head.Model
+	sizes := m.sizes()
+	for _, v := range sizes {
+		if v < 0 {
+			return nil, fmt.Errorf("binary model has layer sizes %v", sizes)
+		}
+	}
+	m.Weight, m.Bias = br.weight(sizes, bits), br.bias(sizes, bits)
+	if br.err != nil {
+		return nil, br.error()
+	}
+	if err := br.arrays(head, bits); err != nil {
+		return nil, err
+	}
+
+	sum := crc.Sum32()
+	var want uint32
+	if err := binary.Read(r, binary.LittleEndian, &want); err != nil {
+		return nil, fmt.Errorf("binary model checksum: %v", err)
+	}
+	if sum != want {
+		return nil, ErrChecksum
+	}
+	return m, nil
+}
+
+// 读取Arrays中的数组，放回优化器状态和训练进度中
+func (o *binaryReader) arrays(head *binaryHeader, bits int) error {
+	m := &head.Model
+	for _, a := range head.Arrays {
+		switch {
+		case a.Name == "Train.BestWeight" && m.Train != nil:
+			m.Train.BestWeight = o.weight(m.sizes(), bits)
+		case a.Name == "Train.BestBias" && m.Train != nil:
+			m.Train.BestBias = o.bias(m.sizes(), bits)
+		case strings.HasPrefix(a.Name, "Optimizer.") && m.Optimizer != nil:
+			v := make([][]Float, len(a.Rows))
+			for i, n := range a.Rows {
+				if n < 0 {
+					return fmt.Errorf("binary model array %s has a row of %d", a.Name, n)
+				}
+				v[i] = o.floats(n, bits)
+			}
+			if m.state == nil {
+				m.state = map[string][][]Float{}
+			}
+			m.state[strings.TrimPrefix(a.Name, "Optimizer.")] = v
+		default:
+			return fmt.Errorf("binary model has an unknown array %q", a.Name)
+		}
+		if o.err != nil {
+			return o.error()
+		}
+	}
+	return nil
+}
+
+// 记录第一个错误，之后的读取都返回零值
+type binaryReader struct {
+	r   io.Reader
+	buf [8]byte
+	err error
+}
+
+func (o *binaryReader) error() error {
+	if o.err == io.EOF || o.err == io.ErrUnexpectedEOF {
+		return errors.New("binary model is truncated")
+	}
+	return o.err
+}
+
+func (o *binaryReader) read(n int) []byte {
+	if o.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	_, o.err = io.ReadFull(o.r, b)
+	return b
+}
+
+// n个字节的无符号整数
+func (o *binaryReader) uintN(n int) uint64 {
+	if o.err != nil {
+		return 0
+	}
+	if _, o.err = io.ReadFull(o.r, o.buf[:n]); o.err != nil {
+		return 0
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(o.buf[i])
+	}
+	return v
+}
+
+// 每层的权重，按行
+func (o *binaryReader) weight(sizes []int, bits int) [][][]Float {
+	var weight [][][]Float
+	for k := 0; k < len(sizes)-1; k++ {
+		data := o.floats(sizes[k]*sizes[k+1], bits)
+		if o.err != nil {
+			return nil
+		}
+		rows := make([][]Float, sizes[k])
+		for i := range rows {
+			rows[i] = data[i*sizes[k+1] : (i+1)*sizes[k+1]]
+		}
+		weight = append(weight, rows)
+	}
+	return weight
+}
+
+// 每层的偏置
+func (o *binaryReader) bias(sizes []int, bits int) [][]Float {
+	var bias [][]Float
+	for k := 1; k < len(sizes); k++ {
+		bias = append(bias, o.floats(sizes[k], bits))
+	}
+	return bias
+}
+
+// n个浮点数，边读边分配，截断的文件不会按头中的大小分配内存
+func (o *binaryReader) floats(n, bits int) []Float {
+	const chunk = 4096
+	var values []Float
+	buf := make([]byte, chunk*bits/8)
+	for len(values) < n && o.err == nil {
+		count := n - len(values)
+		if count > chunk {
+			count = chunk
+		}
+		b := buf[:count*bits/8]
+		if _, o.err = io.ReadFull(o.r, b); o.err != nil {
+			break
+		}
+		for i := 0; i < count; i++ {
+			if bits == 32 {
+				values = append(values, Float(math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))))
+			} else {
+				values = append(values, Float(math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))))
+			}
+		}
+	}
+	return values
+}
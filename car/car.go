@@ -1,5 +1,7 @@
 package main
 
+import "nn"
+
 // CAR ...
 type CAR struct {
 	alive    bool
@@ -20,7 +22,7 @@ func (o *CAR) restart() {
 	o.x = WIDTH / 2
 }
 
-func (o *CAR) getInputs() []float64 {
+func (o *CAR) getInputs() []nn.Float {
 	blockIndex := 0
 firstFor:
 	for i := HEIGHT - 1; i >= 0; i-- {
@@ -32,13 +34,13 @@ firstFor:
 		}
 	}
 
-	block := make([]float64, WIDTH)
+	block := make([]nn.Float, WIDTH)
 	for x := 0; x < WIDTH; x++ {
 		if screen[blockIndex][x] == SIGBLOCK {
 			block[x] = 1
 		}
 	}
-	return append(block, float64(blockIndex)/float64(HEIGHT), float64(o.x)/float64(WIDTH))
+	return append(block, nn.Float(blockIndex)/HEIGHT, nn.Float(o.x)/WIDTH)
 }
 func (o *CAR) control(x int) {
 
@@ -76,7 +76,7 @@ func main() {
 		}
 	}()
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	<-c
 }
@@ -132,7 +132,7 @@ func draw() {
 		inputs := car.getInputs()
 		res := ais[index].Right(inputs)
 		// fmt.Println(inputs, res)
-		cars[index].control(int(math.Round(res[0] * WIDTH)))
+		cars[index].control(int(math.Round(float64(res[0]) * WIDTH)))
 
 		cars[index].update()
 		if !car.alive {
@@ -200,6 +200,9 @@ func copyWeightBias(dst, src *nn.NN) {
 			}
 		}
 	}
+	for k1, v1 := range src.Bias {
+		copy(dst.Bias[k1], v1)
+	}
 }
 
 func cross(a, b *nn.NN) *nn.NN {
@@ -218,13 +221,16 @@ func cross(a, b *nn.NN) *nn.NN {
 				b.Weight[i][k1][k2] = x
 			}
 		}
+		for k1 := range a.Bias[i] {
+			a.Bias[i][k1], b.Bias[i][k1] = b.Bias[i][k1], a.Bias[i][k1]
+		}
 	}
 	return map[int]*nn.NN{0: m, 1: n}[RandIntn(0, 1)]
 }
 
-func mutate(gene float64) float64 {
+func mutate(gene nn.Float) nn.Float {
 	if RandIntn(1, 10) <= 2 {
-		gene += gene*(mrand.Float64()-0.5)*3 + (mrand.Float64() - 0.5)
+		gene += gene*nn.Float(mrand.Float64()-0.5)*3 + nn.Float(mrand.Float64()-0.5)
 	}
 	return gene
 }
@@ -236,6 +242,11 @@ func mutation(x *nn.NN) {
 			}
 		}
 	}
+	for k1, v1 := range x.Bias {
+		for k2 := range v1 {
+			x.Bias[k1][k2] = mutate(x.Bias[k1][k2])
+		}
+	}
 }
 
 // RandIntn return min <= x <= max
@@ -0,0 +1,258 @@
+package nn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ErrInterrupted 训练被SIGINT/SIGTERM中断，已经保存了检查点
+var ErrInterrupted = errors.New("training interrupted")
+
+// Checkpointing 训练时定期保存检查点，用Resume从检查点继续训练。
+// 检查点是带有训练进度的二进制模型文件，包括权重、优化器和学习率调整的状态、随机数、轮数、统计和EarlyStop的最好指标，不包括样本。
+// 训练被取消时也保存检查点，从取消时那一批的开始继续；Async时从那一轮的开始继续。
+// 文件名为Prefix-序号.bin，序号每次保存加1，回滚后修正次数变小也不影响顺序
+type Checkpointing struct {
+	Dir          string        // 保存的目录，默认当前目录
+	Prefix       string        // 文件名前缀，默认checkpoint
+	Every        int           // 每几轮保存一次，0为不按轮数
+	Interval     time.Duration // 每隔多久保存一次，在每批结束时检查，0为不按时间
+	Keep         int           // 保留最近的几个检查点，默认3
+	IgnoreSignal bool          // 不处理SIGINT/SIGTERM，否则收到时保存检查点并停止训练，返回ErrInterrupted
+}
+
+func (o *Checkpointing) prefix() string {
+	if o.Prefix == "" {
+		return "checkpoint"
+	}
+	return o.Prefix
+}
+
+func (o *Checkpointing) keep() int {
+	if o.Keep <= 0 {
+		return 3
+	}
+	return o.Keep
+}
+
+// 检查点按序号排序，只包括Prefix-序号.bin，不包括前缀更长的其它检查点
+func (o *Checkpointing) files() ([]string, error) {
+	dir := o.Dir
+	if dir == "" {
+		dir = "."
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	seqs := map[string]int64{}
+	for _, v := range infos {
+		if n, ok := o.sequence(v.Name()); ok && !v.IsDir() {
+			name := filepath.Join(o.Dir, v.Name())
+			files, seqs[name] = append(files, name), n
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return seqs[files[i]] < seqs[files[j]] })
+	return files, nil
+}
+
+// 检查点文件名中的序号
+func (o *Checkpointing) sequence(fileName string) (int64, bool) {
+	m := regexp.MustCompile(`^` + regexp.QuoteMeta(o.prefix()) + `-([0-9]+)\.bin$`).FindStringSubmatch(filepath.Base(fileName))
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	return n, err == nil
+}
+
+// Latest 最新的检查点，没有时返回""
+func (o *Checkpointing) Latest() (string, error) {
+	files, err := o.files()
+	if err != nil || len(files) == 0 {
+		return "", err
+	}
+	return files[len(files)-1], nil
+}
+
+// TrainState 检查点中的训练进度
+type TrainState struct {
+	Stats    TrainStats
+	Epoch    int     // 继续训练的轮数，从1开始
+	Position int     // 这一轮已经训练的样本数，在一批的开始
+	Order    []int   `json:",omitempty"` // 这一轮的样本顺序，nil时重新生成
+	Max, Sum float64 // 这一轮已经训练的样本的最大误差和误差之和
+	Rand     uint64  // 随机数源的状态
+
+	// EarlyStop的状态
+	Best       float64
+	BestEpoch  int
+	Wait       int
+	BestWeight [][][]Float `json:",omitempty"`
+	BestBias   [][]Float   `json:",omitempty"`
+}
+
+// 一次训练中的检查点和信号
+type checkpointer struct {
+	nn      *NN
+	config  *Checkpointing
+	ctx     context.Context // 收到信号时取消
+	cancel  context.CancelFunc
+	signals chan os.Signal
+	got     chan os.Signal // 收到的信号
+	start   time.Time      // 训练开始的时间，包括检查点之前的用时
+	last    time.Time      // 上一次保存的时间
+}
+
+func (o *NN) newCheckpointer(ctx context.Context, start time.Time) *checkpointer {
+	c := &checkpointer{nn: o, config: o.Checkpoint, ctx: ctx, start: start, last: time.Now()}
+	if c.config == nil || c.config.IgnoreSignal {
+		return c
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.signals, c.got = make(chan os.Signal, 1), make(chan os.Signal, 1)
+	signal.Notify(c.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-c.signals:
+			c.got <- s
+			c.cancel()
+		case <-c.ctx.Done():
+		}
+	}()
+	return c
+}
+
+func (o *checkpointer) stop() {
+	if o.cancel != nil {
+		signal.Stop(o.signals)
+		o.cancel()
+	}
+}
+
+// 是否应该保存，epoch为刚完成的轮数，0为一批结束
+func (o *checkpointer) due(epoch int) bool {
+	if o.config == nil {
+		return false
+	}
+	if epoch > 0 && o.config.Every > 0 && epoch%o.config.Every == 0 {
+		return true
+	}
+	return o.config.Interval > 0 && time.Since(o.last) >= o.config.Interval
+}
+
+func (o *checkpointer) save(state *TrainState) error {
+	o.last = time.Now()
+	state.Stats.Duration = o.last.Sub(o.start)
+	_, err := o.nn.saveCheckpoint(o.config, state)
+	return err
+}
+
+// 训练被取消时保存检查点，收到信号时返回ErrInterrupted
+func (o *checkpointer) cancelled(err error, state *TrainState) error {
+	if o.config != nil {
+		if err := o.save(state); err != nil {
+			return err
+		}
+	}
+	select {
+	case s := <-o.got:
+		return fmt.Errorf("%w: %v", ErrInterrupted, s)
+	default:
+	}
+	return err
+}
+
+// 保存检查点并删除较旧的，先写入临时文件再改名
+func (o *NN) saveCheckpoint(config *Checkpointing, state *TrainState) (string, error) {
+	o.rand()
+	state.Rand = o.src.state
+	if es := o.EarlyStop; es != nil {
+		state.Best, state.BestEpoch, state.Wait = es.best, es.bestEpoch, es.wait
+		state.BestWeight, state.BestBias = es.weight, es.bias
+	}
+	m, err := o.model(true)
+	if err != nil {
+		return "", err
+	}
+	m.Train = state
+
+	files, err := config.files()
+	if err != nil {
+		return "", err
+	}
+	var seq int64
+	if len(files) > 0 {
+		seq, _ = config.sequence(files[len(files)-1])
+	}
+	fileName := filepath.Join(config.Dir, fmt.Sprintf("%s-%010d.bin", config.prefix(), seq+1))
+	f, err := os.Create(fileName + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	if err := writeBinary(f, m, floatBits); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Rename(f.Name(), fileName); err != nil {
+		return "", err
+	}
+
+	if files, err = config.files(); err != nil {
+		return fileName, err
+	}
+	for len(files) > config.keep() {
+		if err := os.Remove(files[0]); err != nil {
+			return fileName, err
+		}
+		files = files[1:]
+	}
+	return fileName, nil
+}
+
+// Resume 从检查点继续训练，o需要设置与保存检查点时相同的结构、样本和回调，
+// 权重、优化器和学习率调整的状态从检查点读取
+func (o *NN) Resume(fileName string) error {
+	_, err := o.ResumeContext(context.Background(), fileName)
+	return err
+}
+
+// ResumeContext 同TrainContext，从检查点继续训练，返回的统计包括检查点之前的部分
+func (o *NN) ResumeContext(ctx context.Context, fileName string) (TrainStats, error) {
+	m, err := loadModel(fileName)
+	if err != nil {
+		return TrainStats{}, err
+	}
+	if m.Train == nil {
+		return TrainStats{}, errors.New("not a checkpoint")
+	}
+	n, err := m.NN()
+	if err != nil {
+		return TrainStats{}, err
+	}
+	if fmt.Sprint(n.sizes()) != fmt.Sprint(o.sizes()) {
+		return TrainStats{}, fmt.Errorf("checkpoint has layers %v, want %v", n.sizes(), o.sizes())
+	}
+	o.Weight, o.Bias, o.w = n.Weight, n.Bias, nil
+	o.Optimizer, o.Schedule, o.Normalization = n.Optimizer, n.Schedule, n.Normalization
+	return o.trainFrom(ctx, m.Train)
+}
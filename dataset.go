@@ -0,0 +1,178 @@
+package nn
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// Dataset 样本集，每个样本的宽度必须与InputNum/OutputNum一致
+type Dataset struct {
+	InputNum  int
+	OutputNum int
+	Data      []StData
+}
+
+// NewDataset ...
+func NewDataset(inputNum, outputNum int) *Dataset {
+	return &Dataset{InputNum: inputNum, OutputNum: outputNum}
+}
+
+// NewDatasetFrom inputs和outputs一一对应，宽度以第一个样本为准
+func NewDatasetFrom(inputs, outputs [][]Float) (*Dataset, error) {
+	if len(inputs) != len(outputs) {
+		return nil, fmt.Errorf("inputs(%d) != outputs(%d)", len(inputs), len(outputs))
+	}
+	o := &Dataset{}
+	if len(inputs) > 0 {
+		o.InputNum, o.OutputNum = len(inputs[0]), len(outputs[0])
+	}
+	for k := range inputs {
+		if err := o.Append(inputs[k], outputs[k]); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// Append 添加样本，不复制input/output
+func (o *Dataset) Append(input, output []Float) error {
+	if err := o.check(len(o.Data), StData{Input: input, Output: output}); err != nil {
+		return err
+	}
+	o.Data = append(o.Data, StData{Input: input, Output: output})
+	return nil
+}
+
+// Len ...
+func (o *Dataset) Len() int {
+	return len(o.Data)
+}
+
+// Validate 检查所有样本的宽度
+func (o *Dataset) Validate() error {
+	for k, v := range o.Data {
+		if err := o.check(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Dataset) check(index int, v StData) error {
+	if len(v.Input) != o.InputNum {
+		return fmt.Errorf("sample %d: %w", index, &ShapeError{Layer: -1, What: "input", Want: o.InputNum, Got: len(v.Input)})
+	}
+	if len(v.Output) != o.OutputNum {
+		return fmt.Errorf("sample %d: %w", index, &ShapeError{Layer: -1, What: "output", Want: o.OutputNum, Got: len(v.Output)})
+	}
+	return nil
+}
+
+// ToJSON ...
+func (o *Dataset) ToJSON() string {
+	bs, _ := json.Marshal(o)
+	return string(bs)
+}
+
+// DatasetFromJSON ...
+func DatasetFromJSON(str string) (*Dataset, error) {
+	o := &Dataset{}
+	if err := json.Unmarshal([]byte(str), o); err != nil {
+		return nil, err
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// SaveJSON ...
+func (o *Dataset) SaveJSON(fileName string) error {
+	return ioutil.WriteFile(fileName, []byte(o.ToJSON()), 0644)
+}
+
+// LoadDatasetJSON ...
+func LoadDatasetJSON(fileName string) (*Dataset, error) {
+	bs, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return DatasetFromJSON(string(bs))
+}
+
+// WriteCSV 每行一个样本，先输入后输出，没有表头，样本长度不符时返回*ShapeError
+func (o *Dataset) WriteCSV(w io.Writer) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	record := make([]string, o.InputNum+o.OutputNum)
+	for _, v := range o.Data {
+		for k, vv := range v.Input {
+			record[k] = strconv.FormatFloat(float64(vv), 'g', -1, floatBits)
+		}
+		for k, vv := range v.Output {
+			record[o.InputNum+k] = strconv.FormatFloat(float64(vv), 'g', -1, floatBits)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadCSV 读取WriteCSV的格式，每行前inputNum列为输入，后outputNum列为输出
+func ReadCSV(r io.Reader, inputNum, outputNum int) (*Dataset, error) {
+	o := NewDataset(inputNum, outputNum)
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = inputNum + outputNum
+	cr.ReuseRecord = true
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return o, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]Float, len(record))
+		for k, v := range record {
+			f, err := strconv.ParseFloat(v, floatBits)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			values[k] = Float(f)
+		}
+		o.Data = append(o.Data, StData{Input: values[:inputNum:inputNum], Output: values[inputNum:]})
+	}
+}
+
+// SaveCSV ...
+func (o *Dataset) SaveCSV(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := o.WriteCSV(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadDatasetCSV ...
+func LoadDatasetCSV(fileName string, inputNum, outputNum int) (*Dataset, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCSV(f, inputNum, outputNum)
+}
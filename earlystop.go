@@ -0,0 +1,107 @@
+package nn
+
+import (
+	"errors"
+
+	"nn/tensor"
+)
+
+// EarlyStopping Validation上的指标连续Patience轮没有改进时结束训练，并恢复到最好的权重
+type EarlyStopping struct {
+	Monitor  string  // 监控的指标，"loss"（默认，越小越好）或"accuracy"（越大越好）
+	Patience int     // 连续多少轮没有改进后结束，默认5
+	MinDelta float64 // 改进超过MinDelta才算改进
+
+	best      float64     // 最好的指标
+	bestEpoch int         // 最好的指标所在轮数
+	wait      int         // 连续没有改进的轮数
+	weight    [][][]Float // 最好的权重
+	bias      [][]Float   // 最好的偏置
+}
+
+func (o *EarlyStopping) patience() int {
+	if o.Patience <= 0 {
+		return 5
+	}
+	return o.Patience
+}
+
+func (o *EarlyStopping) check() error {
+	if o.Monitor != "" && o.Monitor != "loss" && o.Monitor != "accuracy" {
+		return errors.New("EarlyStop.Monitor must be loss or accuracy")
+	}
+	return nil
+}
+
+func (o *EarlyStopping) reset() {
+	o.best, o.bestEpoch, o.wait = 0, 0, 0
+	o.weight, o.bias = nil, nil
+}
+
+// 记录一轮的指标，返回是否应该结束
+func (o *EarlyStopping) update(nn *NN, epoch int, loss, accuracy float64) bool {
+	metric := loss
+	if o.Monitor == "accuracy" {
+		metric = -accuracy
+	}
+
+	if o.bestEpoch == 0 || metric < o.best-o.MinDelta {
+		o.best, o.bestEpoch, o.wait = metric, epoch, 0
+		o.weight, o.bias = copyWeight(nn.Weight, o.weight), copyBias(nn.Bias, o.bias)
+		return false
+	}
+	o.wait++
+	return o.wait >= o.patience()
+}
+
+// 恢复到最好的权重
+func (o *EarlyStopping) restore(nn *NN) {
+	if o.bestEpoch == 0 {
+		return
+	}
+	copyWeight(o.weight, nn.Weight)
+	copyBias(o.bias, nn.Bias)
+}
+
+// Evaluate 返回data的平均误差和成功率，平均误差包括正则化项，Softmax时按最大值的位置判断是否成功，否则按误差是否小于MinDiff
+func (o *NN) Evaluate(data []StData) (loss, accuracy float64) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	success := 0
+	for _, v := range data {
+		o.Right(v.Input)
+		diff := o.diff(o.Output, v.Output)
+		loss += diff
+		if o.Softmax {
+			if tensor.Argmax(o.Output) == tensor.Argmax(v.Output) {
+				success++
+			}
+		} else if diff < o.MinDiff {
+			success++
+		}
+	}
+	return loss/float64(len(data)) + o.penalty(), float64(success) / float64(len(data))
+}
+
+// 复制权重到dst，dst形状不同时重新分配
+func copyWeight(src, dst [][][]Float) [][][]Float {
+	if len(dst) != len(src) {
+		dst = make([][][]Float, len(src))
+	}
+	for k, v := range src {
+		dst[k] = copyBias(v, dst[k])
+	}
+	return dst
+}
+
+// 复制偏置到dst，dst形状不同时重新分配
+func copyBias(src, dst [][]Float) [][]Float {
+	if !sameShape(dst, src) {
+		dst = zerosLike(src)
+	}
+	for k, v := range src {
+		copy(dst[k], v)
+	}
+	return dst
+}
@@ -0,0 +1,81 @@
+package nn
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// EventType 训练事件类型
+type EventType int
+
+// ...
+const (
+	EventEpochStart EventType = iota // 一轮开始
+	EventEpochEnd                    // 一轮结束
+	EventStep                        // 修正一次权重
+	EventEvaluate                    // 用Test检测一次
+	EventEarlyStop                   // 未达到Count提前结束
+	EventTrainEnd                    // 训练结束
+	EventRollback                    // 出现NaN/Inf，回滚到这一轮的开始，Reason为错误
+)
+
+var eventNames = map[EventType]string{
+	EventEpochStart: "epoch_start",
+	EventEpochEnd:   "epoch_end",
+	EventStep:       "step",
+	EventEvaluate:   "evaluate",
+	EventEarlyStop:  "early_stop",
+	EventTrainEnd:   "train_end",
+	EventRollback:   "rollback",
+}
+
+func (o EventType) String() string {
+	if name, ok := eventNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventType(%d)", int(o))
+}
+
+// Event 训练事件
+type Event struct {
+	Type        EventType
+	Epoch       int           // 当前轮数，从1开始
+	Epochs      int           // 总轮数
+	Study       int           // 已学习的样本数
+	Total       int           // 计划学习的样本数
+	Step        int           // 已修正权重的次数
+	Loss        float64       // 当前一轮的平均误差
+	MaxDiff     float64       // 当前一轮的最大误差
+	Accuracy    float64       // 最后一次检测的成功率
+	Learn       float64       // 当前的学习率
+	ValLoss     float64       // 验证集的平均误差
+	ValAccuracy float64       // 验证集的成功率
+	Throughput  float64       // 每秒学习的样本数
+	Elapsed     time.Duration // 已用时间
+	ETA         time.Duration // 预计剩余时间
+	Reason      string        // 提前结束的原因
+}
+
+// ConsoleReporter 输出训练进度到w，用于EventCallback
+func ConsoleReporter(w io.Writer) func(e Event) {
+	line := 0 // 已换行的10%进度
+	return func(e Event) {
+		switch e.Type {
+		case EventEvaluate:
+			fmt.Fprintf(w, "\r训练：%v/%v(%.1f%%) | 误差：%0.8f | 成功率：%.2f%% | 速度：%.0f/s | 剩余：%v",
+				e.Study, e.Total, float64(e.Study)/float64(e.Total)*100, e.MaxDiff, e.Accuracy*100, e.Throughput, e.ETA.Round(time.Second))
+			if e.Total > 0 && e.Study*10/e.Total > line {
+				line = e.Study * 10 / e.Total
+				fmt.Fprintln(w)
+			}
+		case EventEarlyStop:
+			fmt.Fprintf(w, "\n提前结束：%v | 轮数：%v | 误差：%0.8f\n", e.Reason, e.Epoch, e.MaxDiff)
+		case EventRollback:
+			fmt.Fprintf(w, "\n回滚：%v\n", e.Reason)
+		case EventTrainEnd:
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "学习次数:", e.Study)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package nn
+
+import "nn/tensor"
+
+// Float 样本、权重和中间结果的类型，默认为float64，编译时加 -tags float32 使用float32，
+// 内存和带宽减半，学习率等参数和误差仍为float64
+type Float = tensor.Float
+
+// Precision 当前的精度，"float64"或"float32"
+const Precision = tensor.Precision
+
+// 用于strconv
+const floatBits = tensor.Bits
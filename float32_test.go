@@ -0,0 +1,73 @@
+//go:build float32
+// +build float32
+
+package nn
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// go test nn -tags float32 -run Test_单精度 -v -count=1
+func Test_单精度(t *testing.T) {
+	if Precision != "float32" || unsafe.Sizeof(Float(0)) != 4 {
+		t.Fatal("precision:", Precision, unsafe.Sizeof(Float(0)))
+	}
+
+	// 逐样本、批量和并行训练
+	for _, v := range []struct{ batch, workers int }{{0, 0}, {4, 0}, {4, 2}} {
+		o := &NN{
+			Name: "单精度", Learn: 0.01, MinDiff: 1e-6, Count: 500,
+			InputNum: 1, OutputNum: 1,
+			Layer:       []int{4},
+			Activations: []Activation{Tanh{}, Identity{}},
+			Optimizer:   &Adam{},
+			Loss:        MSE{},
+			BatchSize:   v.batch,
+			Workers:     v.workers,
+			Weight:      [][][]Float{{{0.5, -0.3, 0.2, 0.7}}, {{0.1}, {-0.4}, {0.6}, {0.3}}},
+		}
+		for i := 0; i < 10; i++ {
+			x := Float(i) / 10
+			o.Data = append(o.Data, StData{Input: []Float{x}, Output: []Float{2*x - 1}})
+		}
+		if err := o.Train(); err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range o.Data {
+			if l := o.Loss.Loss(o.Right(d.Input), d.Output); l > 0.01 {
+				t.Fatalf("batch %d workers %d: loss %v", v.batch, v.workers, l)
+			}
+		}
+	}
+
+	// 读取float64保存的权重时转换为float32
+	o := &NN{InputNum: 1, OutputNum: 1, Layer: []int{1}}
+	str := `{"Weight":[[[0.1234567890123456]],[[-2.5]]],"Bias":[[0.3],[0]],"Precision":"float64"}`
+	if err := o.FromJSON(str); err != nil {
+		t.Fatal(err)
+	}
+	if o.Weight[0][0][0] != Float(0.1234567890123456) || o.Bias[0][0] != Float(0.3) {
+		t.Fatal("load:", o.Weight, o.Bias)
+	}
+	if str := o.ToJSON(); !strings.HasSuffix(str, `"Precision":"float32"}`) {
+		t.Fatal("json:", str)
+	}
+
+	// 64位和32位的二进制模型
+	for _, bits := range []int{64, 32} {
+		buf := &bytes.Buffer{}
+		if err := o.writeBinary(buf, bits); err != nil {
+			t.Fatal(err)
+		}
+		n, err := ReadModel(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.ToJSON() != o.ToJSON() {
+			t.Fatal("binary:", bits, n.ToJSON())
+		}
+	}
+}
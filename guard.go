@@ -0,0 +1,215 @@
+package nn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// ErrNonFinite 训练时出现NaN或Inf，用errors.Is判断
+var ErrNonFinite = errors.New("non-finite value")
+
+// NumericError 出现NaN或Inf的位置
+type NumericError struct {
+	Epoch  int     // 第几轮，从1开始
+	Step   int     // 第几次修正，从1开始
+	What   string  // "input"、"activation"、"loss"、"weight gradient"、"bias gradient"、"weight"或"bias"
+	Layer  int     // 第几层，-1为不属于某一层
+	Sample int     // 样本在Data中的位置，-1为不属于某个样本
+	Index  int     // 在这一层中的位置
+	Value  float64 // NaN、+Inf或-Inf
+}
+
+func (e *NumericError) Error() string {
+	s := fmt.Sprintf("%s: epoch %d step %d:", ErrNonFinite, e.Epoch, e.Step)
+	if e.Sample >= 0 {
+		s += fmt.Sprintf(" sample %d", e.Sample)
+	}
+	if e.Layer >= 0 {
+		s += fmt.Sprintf(" layer %d", e.Layer)
+	}
+	return fmt.Sprintf("%s %s[%d] is %v", s, e.What, e.Index, e.Value)
+}
+
+// Is ...
+func (e *NumericError) Is(target error) bool {
+	return target == ErrNonFinite
+}
+
+// NumericGuard 训练时每次修正前检查损失和梯度，修正后检查权重和偏置，出现NaN或Inf时停止训练并返回*NumericError，
+// Rollback时回滚到这一轮开始时的权重、偏置和优化器状态，学习率乘以Decay后重新训练这一轮
+type NumericGuard struct {
+	Rollback    bool    // 回滚并继续训练，否则停止
+	MaxRollback int     // 一轮中最多回滚几次，超过后停止，默认3
+	Decay       float64 // 每次回滚后学习率乘以Decay，默认0.5
+
+	scale     float64 // 学习率的倍数
+	rollbacks int     // 这一轮已经回滚的次数
+	last      *guardState
+}
+
+// 回滚的位置
+type guardState struct {
+	weight    [][][]Float
+	bias      [][]Float
+	optimizer []byte // 优化器状态的JSON
+	stats     TrainStats
+	position  int
+	order     []int
+	max, sum  float64
+}
+
+func (o *NumericGuard) maxRollback() int {
+	if o.MaxRollback <= 0 {
+		return 3
+	}
+	return o.MaxRollback
+}
+
+func (o *NumericGuard) decay() float64 {
+	if o.Decay <= 0 || o.Decay >= 1 {
+		return 0.5
+	}
+	return o.Decay
+}
+
+func (o *NumericGuard) reset() {
+	o.scale, o.rollbacks, o.last = 1, 0, nil
+}
+
+// 保存回滚的位置，不Rollback时不保存
+func (o *NumericGuard) save(nn *NN, stats TrainStats, position int, order []int, max, sum float64) error {
+	if !o.Rollback {
+		return nil
+	}
+	if o.last == nil {
+		o.last = &guardState{}
+	}
+	s := o.last
+	s.weight, s.bias = copyWeight(nn.Weight, s.weight), copyBias(nn.Bias, s.bias)
+	s.optimizer = nil
+	if nn.Optimizer != nil {
+		bs, err := json.Marshal(nn.Optimizer)
+		if err != nil {
+			return fmt.Errorf("guard: %v", err)
+		}
+		s.optimizer = bs
+	}
+	s.stats = stats
+	s.stats.Workers = append([]TrainStats(nil), stats.Workers...)
+	s.position, s.order, s.max, s.sum = position, append(s.order[:0], order...), max, sum
+	return nil
+}
+
+// 回滚到上一次保存的位置并降低学习率，不能回滚时返回false
+func (o *NumericGuard) restore(nn *NN) (bool, error) {
+	if !o.Rollback || o.last == nil || o.rollbacks >= o.maxRollback() {
+		return false, nil
+	}
+	s := o.last
+	copyWeight(s.weight, nn.Weight)
+	copyBias(s.bias, nn.Bias)
+	if nn.Optimizer != nil {
+		v, err := decode(reflect.TypeOf(nn.Optimizer), s.optimizer)
+		if err != nil {
+			return false, fmt.Errorf("guard: %v", err)
+		}
+		nn.Optimizer = v.(Optimizer)
+	}
+	o.rollbacks++
+	o.scale *= o.decay()
+	return true, nil
+}
+
+// 第一个NaN或Inf的位置，没有时返回-1
+func nonFinite(v []Float) int {
+	for k, x := range v {
+		if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+			return k
+		}
+	}
+	return -1
+}
+
+// 检查一批样本的误差和累加的梯度，误差不是有限数时找出第一个出现NaN或Inf的层
+func (o *NN) checkStep(samples []int, diffs []float64) *NumericError {
+	for k, index := range samples {
+		if diff := diffs[k]; math.IsNaN(diff) || math.IsInf(diff, 0) {
+			if e := o.locate(index); e != nil {
+				return e
+			}
+			return &NumericError{What: "loss", Layer: -1, Sample: index, Value: diff}
+		}
+	}
+	g := o.gradient()
+	for k, w := range g.w {
+		if i := nonFinite(w.Data); i >= 0 {
+			return &NumericError{What: "weight gradient", Layer: k, Sample: -1, Index: i, Value: float64(w.Data[i])}
+		}
+		if i := nonFinite(g.b[k]); i >= 0 {
+			return &NumericError{What: "bias gradient", Layer: k, Sample: -1, Index: i, Value: float64(g.b[k][i])}
+		}
+	}
+	return nil
+}
+
+// 重新计算第index个样本，找出第一个出现NaN或Inf的输入或激活值
+func (o *NN) locate(index int) *NumericError {
+	input := o.Data[index].Input
+	if i := nonFinite(input); i >= 0 {
+		return &NumericError{What: "input", Layer: -1, Sample: index, Index: i, Value: float64(input[i])}
+	}
+	ws := o.workspace()
+	o.forward(input, ws.z, ws.a)
+	for k, a := range ws.a {
+		if i := nonFinite(a); i >= 0 {
+			return &NumericError{What: "activation", Layer: k, Sample: index, Index: i, Value: float64(a[i])}
+		}
+	}
+	return nil
+}
+
+// 检查权重和偏置
+func (o *NN) checkParams() *NumericError {
+	for k, w := range o.Weight {
+		for r, row := range w {
+			if i := nonFinite(row); i >= 0 {
+				return &NumericError{What: "weight", Layer: k, Sample: -1, Index: r*len(row) + i, Value: float64(row[i])}
+			}
+		}
+		if i := nonFinite(o.Bias[k]); i >= 0 {
+			return &NumericError{What: "bias", Layer: k, Sample: -1, Index: i, Value: float64(o.Bias[k][i])}
+		}
+	}
+	return nil
+}
+
+// 梯度裁剪，g为n个样本累加的梯度，按平均值裁剪：
+// ClipValue限制每个梯度的绝对值，ClipNorm限制所有梯度的L2范数
+func (o *NN) clip(g *gradient, n int) {
+	if o.ClipValue > 0 {
+		limit := Float(o.ClipValue * float64(n))
+		g.each(func(v []Float) {
+			for k, x := range v {
+				if x > limit {
+					v[k] = limit
+				} else if x < -limit {
+					v[k] = -limit
+				}
+			}
+		})
+	}
+	if o.ClipNorm > 0 {
+		sum := 0.0
+		g.each(func(v []Float) {
+			for _, x := range v {
+				sum += float64(x) * float64(x)
+			}
+		})
+		if norm, limit := math.Sqrt(sum), o.ClipNorm*float64(n); norm > limit {
+			g.scale(limit / norm)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package nn
+
+import (
+	"math"
+	mrand "math/rand"
+
+	"nn/tensor"
+)
+
+// Initializer 权重初始化，w为[输入][输出]，rnd为NN自己的随机数
+type Initializer interface {
+	Init(w [][]Float, rnd *mrand.Rand)
+}
+
+// InitFunc 自定义初始化函数
+type InitFunc func(w [][]Float, rnd *mrand.Rand)
+
+// Init ...
+func (f InitFunc) Init(w [][]Float, rnd *mrand.Rand) { f(w, rnd) }
+
+// Zeros 全部为0
+type Zeros struct{}
+
+// Init ...
+func (Zeros) Init(w [][]Float, rnd *mrand.Rand) {
+	fill(w, func() float64 { return 0 })
+}
+
+// XavierUniform Glorot均匀分布 ±sqrt(6/(fanIn+fanOut))，适合sigmoid/tanh
+type XavierUniform struct{}
+
+// Init ...
+func (XavierUniform) Init(w [][]Float, rnd *mrand.Rand) {
+	in, out := fans(w)
+	uniform(w, rnd, math.Sqrt(6/float64(in+out)))
+}
+
+// XavierNormal Glorot正态分布 std=sqrt(2/(fanIn+fanOut))
+type XavierNormal struct{}
+
+// Init ...
+func (XavierNormal) Init(w [][]Float, rnd *mrand.Rand) {
+	in, out := fans(w)
+	normal(w, rnd, math.Sqrt(2/float64(in+out)))
+}
+
+// HeUniform Kaiming均匀分布 ±sqrt(6/fanIn)，适合ReLU
+type HeUniform struct{}
+
+// Init ...
+func (HeUniform) Init(w [][]Float, rnd *mrand.Rand) {
+	in, _ := fans(w)
+	uniform(w, rnd, math.Sqrt(6/float64(in)))
+}
+
+// HeNormal Kaiming正态分布 std=sqrt(2/fanIn)
+type HeNormal struct{}
+
+// Init ...
+func (HeNormal) Init(w [][]Float, rnd *mrand.Rand) {
+	in, _ := fans(w)
+	normal(w, rnd, math.Sqrt(2/float64(in)))
+}
+
+// LeCunUniform 均匀分布 ±sqrt(3/fanIn)
+type LeCunUniform struct{}
+
+// Init ...
+func (LeCunUniform) Init(w [][]Float, rnd *mrand.Rand) {
+	in, _ := fans(w)
+	uniform(w, rnd, math.Sqrt(3/float64(in)))
+}
+
+// LeCunNormal 正态分布 std=sqrt(1/fanIn)
+type LeCunNormal struct{}
+
+// Init ...
+func (LeCunNormal) Init(w [][]Float, rnd *mrand.Rand) {
+	in, _ := fans(w)
+	normal(w, rnd, math.Sqrt(1/float64(in)))
+}
+
+// Orthogonal 正交矩阵乘以Gain（默认1）
+type Orthogonal struct {
+	Gain float64
+}
+
+// Init ...
+func (o Orthogonal) Init(w [][]Float, rnd *mrand.Rand) {
+	in, out := fans(w)
+	// 对较长的一边做Gram-Schmidt正交化
+	rows, cols := in, out
+	if rows < cols {
+		rows, cols = cols, rows
+	}
+	q := make([][]Float, cols) // 每个元素为一列
+	for j := range q {
+		for {
+			q[j] = make([]Float, rows)
+			for i := range q[j] {
+				q[j][i] = Float(rnd.NormFloat64())
+			}
+			for k := 0; k < j; k++ {
+				tensor.Axpy(-tensor.Dot(q[j], q[k]), q[k], q[j])
+			}
+			// 线性相关时重新生成
+			if norm := Float(math.Sqrt(float64(tensor.Dot(q[j], q[j])))); norm > 1e-10 {
+				for i := range q[j] {
+					q[j][i] /= norm
+				}
+				break
+			}
+		}
+	}
+
+	gain := Float(defaultFloat(o.Gain, 1))
+	for i := 0; i < in; i++ {
+		for j := 0; j < out; j++ {
+			if in >= out {
+				w[i][j] = gain * q[j][i]
+			} else {
+				w[i][j] = gain * q[i][j]
+			}
+		}
+	}
+}
+
+// 旧的初始化方法，±[0.1,0.9]均匀分布
+type legacyInit struct{}
+
+func (legacyInit) Init(w [][]Float, rnd *mrand.Rand) {
+	min, max := 0.1, 0.9
+	r := func() float64 {
+		for {
+			if x := rnd.Float64()*(max-min) + min; x != 0 {
+				return x
+			}
+		}
+	}
+	fill(w, func() float64 {
+		if r() < 0.5 {
+			return r()
+		}
+		return -r()
+	})
+}
+
+func fans(w [][]Float) (in, out int) {
+	if len(w) > 0 {
+		out = len(w[0])
+	}
+	return len(w), out
+}
+
+func fill(w [][]Float, f func() float64) {
+	for _, row := range w {
+		for k := range row {
+			row[k] = Float(f())
+		}
+	}
+}
+
+func uniform(w [][]Float, rnd *mrand.Rand, limit float64) {
+	for _, row := range w {
+		tensor.FromSlice(row, len(row)).RandUniform(rnd, Float(limit))
+	}
+}
+
+func normal(w [][]Float, rnd *mrand.Rand, std float64) {
+	for _, row := range w {
+		tensor.FromSlice(row, len(row)).RandNormal(rnd, Float(std))
+	}
+}
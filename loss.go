@@ -0,0 +1,189 @@
+package nn
+
+import (
+	"math"
+
+	"nn/tensor"
+)
+
+// Loss 损失函数
+type Loss interface {
+	Loss(output, target []Float) float64   // 误差
+	Gradient(output, target, grad []Float) // 误差对输出层的导数，写入grad
+}
+
+// 防止log(0)和除0
+const lossEpsilon = 1e-12
+
+// MSE 均方误差 mean((y-t)^2)
+type MSE struct{}
+
+// Loss ...
+func (MSE) Loss(output, target []Float) float64 {
+	return tensor.SumZip(output, target, squaredError) / float64(len(output))
+}
+
+// Gradient ...
+func (MSE) Gradient(output, target, grad []Float) {
+	n := float64(len(output))
+	tensor.Zip3(output, target, grad, func(y, t, _ Float) Float { return Float(2 * float64(y-t) / n) })
+}
+
+// MAE 平均绝对误差 mean(|y-t|)
+type MAE struct{}
+
+// Loss ...
+func (MAE) Loss(output, target []Float) float64 {
+	sum := tensor.SumZip(output, target, func(y, t Float) float64 { return math.Abs(float64(y - t)) })
+	return sum / float64(len(output))
+}
+
+// Gradient ...
+func (MAE) Gradient(output, target, grad []Float) {
+	n := float64(len(output))
+	tensor.Zip3(output, target, grad, func(y, t, _ Float) Float { return Float(sign(float64(y-t)) / n) })
+}
+
+// Huber |y-t|<=Delta时为平方误差，否则为线性误差，Delta默认1
+type Huber struct {
+	Delta float64
+}
+
+func (o Huber) delta() float64 {
+	if o.Delta <= 0 {
+		return 1
+	}
+	return o.Delta
+}
+
+// Loss ...
+func (o Huber) Loss(output, target []Float) float64 {
+	d := o.delta()
+	sum := tensor.SumZip(output, target, func(y, t Float) float64 {
+		r := math.Abs(float64(y - t))
+		if r <= d {
+			return 0.5 * r * r
+		}
+		return d * (r - 0.5*d)
+	})
+	return sum / float64(len(output))
+}
+
+// Gradient ...
+func (o Huber) Gradient(output, target, grad []Float) {
+	d := o.delta()
+	n := float64(len(output))
+	tensor.Zip3(output, target, grad, func(y, t, _ Float) Float {
+		r := float64(y - t)
+		if math.Abs(r) > d {
+			r = d * sign(r)
+		}
+		return Float(r / n)
+	})
+}
+
+// BinaryCrossEntropy 二元交叉熵，输出层应为(0,1)，通常配合Sigmoid
+type BinaryCrossEntropy struct{}
+
+// Loss ...
+func (BinaryCrossEntropy) Loss(output, target []Float) float64 {
+	sum := tensor.SumZip(output, target, func(y, t Float) float64 {
+		p, q := clamp(float64(y), lossEpsilon, 1-lossEpsilon), float64(t)
+		return -(q*math.Log(p) + (1-q)*math.Log(1-p))
+	})
+	return sum / float64(len(output))
+}
+
+// Gradient ...
+func (BinaryCrossEntropy) Gradient(output, target, grad []Float) {
+	n := float64(len(output))
+	tensor.Zip3(output, target, grad, func(y, t, _ Float) Float {
+		p := clamp(float64(y), lossEpsilon, 1-lossEpsilon)
+		return Float((p - float64(t)) / (p * (1 - p)) / n)
+	})
+}
+
+// CategoricalCrossEntropy 多分类交叉熵 -sum(t*log(y))，通常配合Softmax
+type CategoricalCrossEntropy struct{}
+
+// Loss ...
+func (CategoricalCrossEntropy) Loss(output, target []Float) float64 {
+	return tensor.SumZip(output, target, func(y, t Float) float64 {
+		if t == 0 {
+			return 0
+		}
+		return -float64(t) * math.Log(math.Max(float64(y), lossEpsilon))
+	})
+}
+
+// Gradient ...
+func (CategoricalCrossEntropy) Gradient(output, target, grad []Float) {
+	tensor.Zip3(output, target, grad, func(y, t, _ Float) Float {
+		return Float(-float64(t) / math.Max(float64(y), lossEpsilon))
+	})
+}
+
+// Quantile 分位数损失，Tau为分位数(0,1)，默认0.5
+type Quantile struct {
+	Tau float64
+}
+
+func (o Quantile) tau() float64 {
+	if o.Tau <= 0 || o.Tau >= 1 {
+		return 0.5
+	}
+	return o.Tau
+}
+
+// Loss ...
+func (o Quantile) Loss(output, target []Float) float64 {
+	tau := o.tau()
+	sum := tensor.SumZip(output, target, func(y, t Float) float64 {
+		r := float64(t - y)
+		return math.Max(tau*r, (tau-1)*r)
+	})
+	return sum / float64(len(output))
+}
+
+// Gradient ...
+func (o Quantile) Gradient(output, target, grad []Float) {
+	tau := o.tau()
+	n := float64(len(output))
+	tensor.Zip3(output, target, grad, func(y, t, _ Float) Float {
+		if t > y {
+			return Float(-tau / n)
+		}
+		return Float((1 - tau) / n)
+	})
+}
+
+// 旧版默认的平方误差 sum((y-t)^2)/2
+type halfSquaredError struct{}
+
+func (halfSquaredError) Loss(output, target []Float) float64 {
+	return tensor.SumZip(output, target, squaredError) / 2
+}
+
+func (halfSquaredError) Gradient(output, target, grad []Float) {
+	tensor.Zip3(output, target, grad, func(y, t, _ Float) Float { return y - t })
+}
+
+// (y-t)^2
+func squaredError(y, t Float) float64 {
+	r := float64(y - t)
+	return r * r
+}
+
+func sign(x float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+func clamp(x, min, max float64) float64 {
+	return math.Min(math.Max(x, min), max)
+}
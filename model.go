@@ -0,0 +1,437 @@
+package nn
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ModelVersion 模型文件的版本，格式不兼容时增加
+const ModelVersion = 1
+
+// Model 自描述的模型文件，包括网络结构、激活函数、损失函数、权重、偏置、归一化参数和超参数，
+// 只用文件就能重建NN。Normalization只在Predict时自动使用，Right/Forward的输入和输出需要调用者转换
+type Model struct {
+	Version   int    // 模型文件的版本
+	Precision string // 保存时的精度，读取时按当前精度转换
+	Name      string
+
+	InputNum      int
+	OutputNum     int
+	Layer         []int
+	Softmax       bool
+	Activations   []*Component // nil为Sigmoid
+	Initializers  []*Component `json:",omitempty"` // 没有注册的保存为nil
+	Loss          *Component   `json:",omitempty"`
+	Optimizer     *Component   `json:",omitempty"` // 包括优化器的状态
+	Schedule      *Component   `json:",omitempty"`
+	Normalization *Normalization
+
+	Regularizations []*Regularization `json:",omitempty"` // 每层的正则化，nil为L1、L2和MaxNorm
+
+	Learn     float64
+	MinDiff   float64
+	Count     int
+	BatchSize int
+	Workers   int
+	Async     bool
+	Shuffle   bool
+	RandSeed  int64
+	EvalEvery int
+	EarlyStop *EarlyStopping `json:",omitempty"`
+	Guard     *NumericGuard  `json:",omitempty"`
+	ClipValue float64        `json:",omitempty"`
+	ClipNorm  float64        `json:",omitempty"`
+	L1        float64        `json:",omitempty"`
+	L2        float64        `json:",omitempty"`
+	MaxNorm   float64        `json:",omitempty"`
+
+	Weight [][][]Float       `json:",omitempty"`
+	Bias   [][]Float         `json:",omitempty"`
+	Meta   map[string]string `json:",omitempty"`
+	Train  *TrainState       `json:",omitempty"` // 检查点中的训练进度
+
+	state map[string][][]Float // 二进制文件中与Optimizer分开保存的状态，见splitState
+}
+
+// Component 激活函数、损失函数、优化器、学习率调整或初始化方法，Type为Register时的类型名，Params为导出的字段
+type Component struct {
+	Type   string
+	Params json.RawMessage `json:",omitempty"`
+}
+
+// 可以保存到模型文件中的类型
+var components = map[string]reflect.Type{}
+
+func init() {
+	Register(
+		Sigmoid{}, Tanh{}, ReLU{}, LeakyReLU{}, ELU{}, Softplus{}, Identity{},
+		MSE{}, MAE{}, Huber{}, BinaryCrossEntropy{}, CategoricalCrossEntropy{}, Quantile{},
+		SGD{}, &Momentum{}, &AdaGrad{}, &RMSProp{}, &Adam{}, &AdamW{},
+		StepDecay{}, ExponentialDecay{}, CosineAnnealing{}, LinearWarmup{}, OneCycle{}, &ReduceOnPlateau{},
+		Zeros{}, XavierUniform{}, XavierNormal{}, HeUniform{}, HeNormal{}, LeCunUniform{}, LeCunNormal{}, Orthogonal{},
+	)
+}
+
+// Register 注册自定义的激活函数、损失函数、优化器、学习率调整或初始化方法，用于保存和读取模型，
+// 方法的接收者为指针时注册指针
+func Register(values ...interface{}) {
+	for _, v := range values {
+		components[componentName(v)] = reflect.TypeOf(v)
+	}
+}
+
+// 类型名，如nn.Sigmoid
+func componentName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+func newComponent(v interface{}) (*Component, error) {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return nil, nil
+	}
+	name := componentName(v)
+	if components[name] != reflect.TypeOf(v) {
+		return nil, fmt.Errorf("%T is not registered", v)
+	}
+	params, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if string(params) == "{}" {
+		params = nil
+	}
+	return &Component{Type: name, Params: params}, nil
+}
+
+// 按注册的类型重建
+func (o *Component) value() (interface{}, error) {
+	if o == nil {
+		return nil, nil
+	}
+	t, ok := components[o.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s is not registered", o.Type)
+	}
+	v, err := decode(t, o.Params)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", o.Type, err)
+	}
+	return v, nil
+}
+
+// 按JSON的params新建类型为t的值
+func decode(t reflect.Type, params []byte) (interface{}, error) {
+	elem := t
+	if t.Kind() == reflect.Ptr {
+		elem = t.Elem()
+	}
+	v := reflect.New(elem)
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, v.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	if t.Kind() == reflect.Ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+var stateType = reflect.TypeOf([][]Float(nil))
+
+// 结构体中[][]Float类型的导出字段，包括嵌入的结构体，name为字段名的路径，如"Adam.M"
+func stateFields(v reflect.Value, prefix string, f func(name string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" || sf.Tag.Get("json") == "-" {
+			continue
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			stateFields(v.Field(i), prefix+sf.Name+".", f)
+		} else if sf.Type == stateType {
+			f(prefix+sf.Name, v.Field(i))
+		}
+	}
+}
+
+// 返回去掉状态的副本和状态，如Adam的M和V，v不是结构体的指针时没有状态
+func splitState(v interface{}) (interface{}, map[string][][]Float) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return v, nil
+	}
+	c := reflect.New(rv.Elem().Type())
+	c.Elem().Set(rv.Elem())
+	var state map[string][][]Float
+	stateFields(c.Elem(), "", func(name string, field reflect.Value) {
+		if field.Len() == 0 {
+			return
+		}
+		if state == nil {
+			state = map[string][][]Float{}
+		}
+		state[name] = field.Interface().([][]Float)
+		field.Set(reflect.Zero(stateType))
+	})
+	return c.Interface(), state
+}
+
+// 把splitState返回的状态放回v
+func setState(v interface{}, state map[string][][]Float) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%T has no state", v)
+	}
+	n := 0
+	stateFields(rv.Elem(), "", func(name string, field reflect.Value) {
+		if s, ok := state[name]; ok {
+			field.Set(reflect.ValueOf(s))
+			n++
+		}
+	})
+	if n != len(state) {
+		return fmt.Errorf("%T does not have all of the saved state", v)
+	}
+	return nil
+}
+
+// MarshalJSON After保存为Component
+func (o LinearWarmup) MarshalJSON() ([]byte, error) {
+	after, err := newComponent(o.After)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Steps int
+		After *Component `json:",omitempty"`
+	}{o.Steps, after})
+}
+
+// UnmarshalJSON ...
+func (o *LinearWarmup) UnmarshalJSON(bs []byte) error {
+	v := struct {
+		Steps int
+		After *Component
+	}{}
+	if err := json.Unmarshal(bs, &v); err != nil {
+		return err
+	}
+	after, err := v.After.value()
+	if err != nil {
+		return err
+	}
+	o.Steps, o.After = v.Steps, nil
+	if after != nil {
+		s, ok := after.(Schedule)
+		if !ok {
+			return fmt.Errorf("%s is not a Schedule", v.After.Type)
+		}
+		o.After = s
+	}
+	return nil
+}
+
+// Model 保存为模型文件的内容
+func (o *NN) Model() (*Model, error) {
+	return o.model(false)
+}
+
+// split时优化器的状态放在Model.state中，不编码为JSON
+func (o *NN) model(split bool) (*Model, error) {
+	if err := checkWeight(o.sizes(), o.Weight, o.Bias); err != nil {
+		return nil, err
+	}
+	m := &Model{
+		Version: ModelVersion, Precision: Precision, Name: o.Name,
+		InputNum: o.InputNum, OutputNum: o.OutputNum, Layer: o.Layer, Softmax: o.Softmax, Normalization: o.Normalization,
+		Learn: o.Learn, MinDiff: o.MinDiff, Count: o.Count,
+		BatchSize: o.BatchSize, Workers: o.Workers, Async: o.Async, Shuffle: o.Shuffle,
+		RandSeed: o.RandSeed, EvalEvery: o.EvalEvery, EarlyStop: o.EarlyStop,
+		Guard: o.Guard, ClipValue: o.ClipValue, ClipNorm: o.ClipNorm,
+		L1: o.L1, L2: o.L2, MaxNorm: o.MaxNorm, Regularizations: o.Regularizations,
+		Weight: o.Weight, Bias: o.Bias, Meta: o.Meta,
+	}
+	var err error
+	for _, v := range o.Activations {
+		c, err := newComponent(v)
+		if err != nil {
+			return nil, err
+		}
+		m.Activations = append(m.Activations, c)
+	}
+	for _, v := range o.Initializers {
+		c, err := newComponent(v)
+		if err != nil {
+			c = nil // 重建权重不需要初始化方法，没有注册的（如InitFunc）保存为nil
+		}
+		m.Initializers = append(m.Initializers, c)
+	}
+	if m.Loss, err = newComponent(o.Loss); err != nil {
+		return nil, err
+	}
+	var optimizer interface{} = o.Optimizer
+	if split {
+		optimizer, m.state = splitState(o.Optimizer)
+	}
+	if m.Optimizer, err = newComponent(optimizer); err != nil {
+		return nil, err
+	}
+	if m.Schedule, err = newComponent(o.Schedule); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// 按InputNum、Layer和OutputNum的每层神经元数量
+func (o *Model) sizes() []int {
+	sizes := append([]int{o.InputNum}, o.Layer...)
+	return append(sizes, o.OutputNum)
+}
+
+// NN 按模型文件重建NN
+func (o *Model) NN() (*NN, error) {
+	if o.Version <= 0 {
+		return nil, fmt.Errorf("not a model file, use LoadWeight for weight files")
+	}
+	if o.Version > ModelVersion {
+		return nil, fmt.Errorf("model version %d is newer than %d", o.Version, ModelVersion)
+	}
+	n := &NN{
+		Name: o.Name, InputNum: o.InputNum, OutputNum: o.OutputNum, Layer: o.Layer, Softmax: o.Softmax, Normalization: o.Normalization,
+		Learn: o.Learn, MinDiff: o.MinDiff, Count: o.Count,
+		BatchSize: o.BatchSize, Workers: o.Workers, Async: o.Async, Shuffle: o.Shuffle,
+		RandSeed: o.RandSeed, EvalEvery: o.EvalEvery, EarlyStop: o.EarlyStop,
+		Guard: o.Guard, ClipValue: o.ClipValue, ClipNorm: o.ClipNorm,
+		L1: o.L1, L2: o.L2, MaxNorm: o.MaxNorm, Regularizations: o.Regularizations,
+		Weight: o.Weight, Bias: o.Bias, Meta: o.Meta,
+	}
+	for _, c := range o.Activations {
+		v, err := c.value()
+		if err != nil {
+			return nil, err
+		}
+		act, ok := v.(Activation)
+		if v != nil && !ok {
+			return nil, fmt.Errorf("%s is not an Activation", c.Type)
+		}
+		n.Activations = append(n.Activations, act)
+	}
+	for _, c := range o.Initializers {
+		v, err := c.value()
+		if err != nil {
+			return nil, err
+		}
+		ini, ok := v.(Initializer)
+		if v != nil && !ok {
+			return nil, fmt.Errorf("%s is not an Initializer", c.Type)
+		}
+		n.Initializers = append(n.Initializers, ini)
+	}
+	if v, err := o.Loss.value(); err != nil {
+		return nil, err
+	} else if v != nil {
+		if n.Loss, _ = v.(Loss); n.Loss == nil {
+			return nil, fmt.Errorf("%s is not a Loss", o.Loss.Type)
+		}
+	}
+	if v, err := o.Optimizer.value(); err != nil {
+		return nil, err
+	} else if v != nil {
+		if n.Optimizer, _ = v.(Optimizer); n.Optimizer == nil {
+			return nil, fmt.Errorf("%s is not an Optimizer", o.Optimizer.Type)
+		}
+	}
+	if len(o.state) > 0 {
+		if err := setState(n.Optimizer, o.state); err != nil {
+			return nil, err
+		}
+	}
+	if v, err := o.Schedule.value(); err != nil {
+		return nil, err
+	} else if v != nil {
+		if n.Schedule, _ = v.(Schedule); n.Schedule == nil {
+			return nil, fmt.Errorf("%s is not a Schedule", o.Schedule.Type)
+		}
+	}
+
+	if err := n.Init(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// WriteModel 以JSON写入模型
+func (o *NN) WriteModel(w io.Writer) error {
+	m, err := o.Model()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(m)
+}
+
+// ReadModel 读取WriteModel或WriteModelBinary写入的模型，按开头的magic判断格式
+func ReadModel(r io.Reader) (*NN, error) {
+	m, err := readModel(r)
+	if err != nil {
+		return nil, err
+	}
+	return m.NN()
+}
+
+func readModel(r io.Reader) (*Model, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(binaryMagic)); string(magic) == binaryMagic {
+		return readBinary(br)
+	}
+	m := &Model{}
+	if err := json.NewDecoder(br).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SaveModel 文件名以.json结尾时保存为JSON，否则为二进制
+func (o *NN) SaveModel(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	write := o.WriteModelBinary
+	if strings.HasSuffix(strings.ToLower(fileName), ".json") {
+		write = o.WriteModel
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadModel 读取SaveModel保存的JSON或二进制模型，不需要事先设置NN的结构，
+// 有Normalization时Predict自动归一化输入并还原输出，Right/Forward的输入和输出需要调用者用Normalization.Input/Output转换
+func LoadModel(fileName string) (*NN, error) {
+	m, err := loadModel(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return m.NN()
+}
+
+func loadModel(fileName string) (*Model, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readModel(f)
+}
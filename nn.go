@@ -1,6 +1,7 @@
 package nn
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,136 +9,454 @@ import (
 	"log"
 	"math"
 	"runtime"
+	"strings"
 	"time"
 
 	mrand "math/rand"
 
 	"github.com/ohko/logger"
+
+	"nn/tensor"
 )
 
 // NN Neural Network
 type NN struct {
 	ll                 *logger.Logger
-	Seed               bool     // 随机前是否先seed
+	Seed               bool     // 随机前是否先用当前时间seed
+	RandSeed           int64    // 随机种子，相同种子得到相同的权重和样本顺序，0为使用当前时间
+	Shuffle            bool     // 每轮训练前打乱样本顺序
 	Name               string   // 名称
 	Learn              float64  // 学习率
-	MinDiff            float64  // 最小误差
+	MinDiff            float64  // 最小误差，按TestCallback或损失函数计算
 	Count              int      // 训练次数
 	Data               []StData // 输入/输出层
 	InputNum           int
 	OutputNum          int
-	Layer              []int                               // 隐藏层数量
-	Hidden             [][]float64                         // 隐藏层
-	Weight             [][][]float64                       // 权重
-	Output             []float64                           // 输出层
-	Test               []StData                            // 测试
-	TestCallback       func(chk, result []float64) float64 // 检测回调函数
+	Layer              []int                             // 隐藏层数量
+	Activations        []Activation                      // 每层激活函数（隐藏层+输出层），nil为Sigmoid
+	Softmax            bool                              // 输出层使用softmax，配合交叉熵训练
+	Initializers       []Initializer                     // 每层权重初始化，nil为±[0.1,0.9]均匀分布
+	Loss               Loss                              // 损失函数，nil为平方误差（Softmax时为交叉熵）
+	Optimizer          Optimizer                         // 优化器，nil为SGD
+	Schedule           Schedule                          // 学习率调整，nil为固定的Learn
+	BatchSize          int                               // 每批样本数，累计梯度取平均后修正，0为逐样本，<0为全部样本
+	Workers            int                               // 每批样本平均分给几个goroutine计算梯度，0或1为不并行
+	Async              bool                              // 异步训练，Workers个goroutine各自训练Data的一部分，不加锁直接修正权重，结果不确定
+	Hidden             [][]Float                         // 隐藏层
+	Weight             [][][]Float                       // 权重[层][输入][输出]，每层的行连续存储
+	Bias               [][]Float                         // 偏置
+	Output             []Float                           // 输出层
+	Test               []StData                          // 测试
+	Validation         []StData                          // 验证集，用于EarlyStop
+	EarlyStop          *EarlyStopping                    // 验证集上的指标不再改进时提前结束
+	Normalization      *Normalization                    // 样本的归一化参数，只在Predict中使用，Right/Forward和训练使用归一化后的样本
+	Meta               map[string]string                 // 自由的元数据，保存在模型文件中
+	Checkpoint         *Checkpointing                    // 定期保存检查点，用Resume继续训练
+	Guard              *NumericGuard                     // 训练时检查NaN/Inf，停止训练或回滚
+	ClipValue          float64                           // 梯度裁剪，每个梯度的绝对值不超过ClipValue，0为不裁剪
+	ClipNorm           float64                           // 梯度裁剪，所有梯度的L2范数不超过ClipNorm，0为不裁剪
+	L1                 float64                           // 所有层权重的L1正则化系数
+	L2                 float64                           // 所有层权重的L2正则化系数
+	MaxNorm            float64                           // 每个神经元输入权重的L2范数上限，0为不限制
+	Regularizations    []*Regularization                 // 每层权重的正则化，nil为使用L1、L2和MaxNorm
+	TestCallback       func(chk, result []Float) float64 // 检测回调函数
 	CheckCallback      func(showLog bool, showPercent bool) float64
 	StudyCountCallback func(study int) // 学习次数回调
+	EventCallback      func(e Event)   // 训练事件回调，可用ConsoleReporter输出进度
+	EvalEvery          int             // 每几轮用Test检测一次，0为Count的1/1000
+
+	w       []*tensor.Tensor // 每层连续存储的权重，与Weight共用内存
+	ws      *Workspace       // Right/Left的缓存
+	grad    *gradient        // 累加的梯度
+	workers []*Workspace     // 并行训练时每个goroutine的缓存
+	rnd     *mrand.Rand      // 随机数
+	src     *randSource      // 随机数源
 }
 
-// StData ...
+// StData 样本
 type StData struct {
-	input  []float64
-	output []float64
+	Input  []Float
+	Output []Float
 }
 
-func sigmoid(x []float64) []float64 {
+func sigmoid(x []Float) []Float {
 	for k, v := range x {
-		x[k] = 1 / (1 + math.Exp(-v))
-		// x[k] = (1 - math.Exp(-2*v)) / (1 + math.Exp(-2*v))
-		// x[k] = 1 - math.Pow(v, 2)
-		// x[k] = math.Sinh(v) / math.Cosh(v)
+		x[k] = Float(Sigmoid{}.Forward(float64(v)))
 	}
 	return x
 }
 
-func (o *NN) matrixMul(input []float64, weight [][]float64) []float64 {
-	z := make([]float64, len(weight[0]))
+// 第index层的激活函数，默认sigmoid
+func (o *NN) activation(index int) Activation {
+	if index < len(o.Activations) && o.Activations[index] != nil {
+		return o.Activations[index]
+	}
+	return Sigmoid{}
+}
 
-	// o.ll.Log0Debug("input:", input)
-	// o.ll.Log0Debug("weigth:", weight)
+// 第index层的权重矩阵，Weight被替换后没有pack时复制一份
+func (o *NN) weight(index int) *tensor.Tensor {
+	if o.packed(index) {
+		return o.w[index]
+	}
+	return tensor.FromRows(o.Weight[index])
+}
 
-	for i := 0; i < len(weight[0]); i++ {
-		for j := 0; j < len(input); j++ {
-			x, y := input[j], weight[j][i]
-			// o.ll.Log0Debug(fmt.Sprint(x, "x", y, "=>", i))
-			z[i] += x * y
+// 第index层的Weight是否还是o.w[index]的每一行
+func (o *NN) packed(index int) bool {
+	if index >= len(o.w) || o.w[index] == nil {
+		return false
+	}
+	w, rows := o.w[index], o.Weight[index]
+	if w.Shape[0] != len(rows) {
+		return false
+	}
+	for i, v := range rows {
+		if len(v) != w.Shape[1] || len(v) > 0 && &v[0] != &w.Data[i*w.Shape[1]] {
+			return false
 		}
 	}
+	return true
+}
 
-	// sigmoid激活
-	z = sigmoid(z)
-	// o.ll.Log0Debug("output:", z)
-	return z
+// 每层权重复制到连续内存中，Weight的每一行指向这块内存
+func (o *NN) pack() {
+	if len(o.w) != len(o.Weight) {
+		o.w = make([]*tensor.Tensor, len(o.Weight))
+	}
+	for k, v := range o.Weight {
+		if !o.packed(k) {
+			o.w[k] = tensor.FromRows(v)
+			o.Weight[k] = o.w[k].Rows()
+		}
+	}
 }
 
-// Right ...
-func (o *NN) Right(output []float64) []float64 {
+// 前向计算，z和a为每层激活前和激活后的值，只读取NN
+func (o *NN) forward(input []Float, z, a [][]Float) []Float {
+	output := input
 	for index := 0; index < len(o.Weight); index++ {
 		// 输入层加权求和
-		output = o.matrixMul(output, o.Weight[index])
-		// 保存
-		if index < len(o.Weight)-1 {
-			o.Hidden[index] = output
-		} else {
-			o.Output = output
-		}
+		copy(z[index], o.Bias[index])
+		tensor.Gemv(true, 1, o.weight(index), output, 1, z[index])
+
+		// 激活
+		output = a[index]
+		o.activate(index, z[index], output)
 	}
+	return output
+}
+
+// 第index层的激活
+func (o *NN) activate(index int, z, a []Float) {
+	if o.Softmax && index == len(o.Weight)-1 {
+		softmax(z, a)
+		return
+	}
+	act := o.activation(index)
+	for k, v := range z {
+		a[k] = Float(act.Forward(float64(v)))
+	}
+}
+
+// 每层输出的宽度
+func (o *NN) layerSizes() []int {
+	sizes := make([]int, len(o.Weight))
+	for k := range o.Weight {
+		sizes[k] = len(o.Bias[k])
+	}
+	return sizes
+}
+
+// Right Output和Hidden使用预先分配的缓存，下一次Right时被覆盖
+func (o *NN) Right(output []Float) []Float {
+	o.pack()
+	ws := o.workspace()
+	o.Output = o.forward(output, ws.z, ws.a)
+	// 保存
+	copy(o.Hidden, ws.a[:len(ws.a)-1])
 
 	return o.Output
 	// o.ll.Log0Debug("last output:", o.Output)
 }
 
-func (o *NN) matrixMul2(input []float64, weightIndex int, layer []float64) []float64 {
-	z := make([]float64, len(o.Weight[weightIndex]))
+// Left 用最后一次Right的结果修正权重
+// func (o *NN) Left(data *StData) {
+func (o *NN) Left(input, output []Float) {
+	o.backward(input, output, o.workspace(), o.gradient())
+	o.step(1, o.Learn)
+	// o.ll.Log0Debug("weight:", o.Weight)
+}
 
-	// o.ll.Log0Debug("input:", input)
-	// o.ll.Log0Debug("weigth:", o.Weight[weightIndex])
-	// o.ll.Log0Debug("layer:", layer)
+// 反向传播，ws为前向计算的结果，梯度累加到g
+func (o *NN) backward(input, output []Float, ws *Workspace, g *gradient) {
+	z, a, d := ws.z, ws.a, ws.d
 
-	for i := 0; i < len(o.Weight[weightIndex]); i++ {
-		for j := 0; j < len(input); j++ {
-			x, y, l := input[j], o.Weight[weightIndex][i][j], layer[i]
-			// o.ll.Log0Debug(fmt.Sprint(x, "x", y, "x", l, "x(1-", l, ")=>z:", i))
-			z[i] += x * y * l * (1 - l)
-			// o.ll.Log0Debug(fmt.Sprint(y, " + ", l, " * ", x, " * ", o.Learn, "=>", y+l*x*o.Learn))
-			o.Weight[weightIndex][i][j] = y + l*x*o.Learn
+	// 计算残差
+	last := len(o.Weight) - 1
+	o.outputDelta(a[last], z[last], output, d[last])
+	// o.ll.Log0Debug("残差:", d[last])
+
+	// 每层残差
+	for index := last; index >= 0; index-- {
+		layer := input
+		if index > 0 {
+			layer = a[index-1]
+		}
+		tensor.Ger(1, layer, d[index], g.w[index])
+		tensor.Axpy(1, d[index], g.b[index])
+		if index == 0 {
+			break
 		}
-	}
 
-	// o.ll.Log0Debug("output:", z)
-	// o.ll.Log0Debug("new weight:", o.Weight[weightIndex])
+		// 传递到上一层，乘上一层激活函数的导数
+		tensor.Gemv(false, 1, o.weight(index), d[index], 0, d[index-1])
+		o.derivative(index-1, z[index-1], a[index-1], d[index-1])
+	}
+}
 
-	return z
+// 残差d乘第index层激活函数的导数
+func (o *NN) derivative(index int, z, a, d []Float) {
+	act := o.activation(index)
+	for k := range d {
+		d[k] *= Float(act.Derivative(float64(z[k]), float64(a[k])))
+	}
 }
 
-// Left ...
-// func (o *NN) Left(data *StData) {
-func (o *NN) Left(input, output []float64) {
-	rdiff := make([]float64, len(o.Output))
-	// 计算残差
-	for k := range output {
-		rdiff[k] = -(o.Output[k] - output[k]) * o.Output[k] * (1 - o.Output[k])
+// 批量训练samples，每层按矩阵计算，梯度累加到g，每个样本的误差写入diffs
+func (o *NN) trainBatch(ws *Workspace, samples []int, diffs []float64, g *gradient) {
+	n := len(samples)
+	ws.batch(n)
+	x := ws.bx.Slice(0, 0, n)
+	for s, index := range samples {
+		copy(x.Row(s), o.Data[index].Input)
 	}
-	// o.ll.Log0Debug("残差:", rdiff)
 
-	// 修正每层残差
-	output1 := rdiff
-	for index := len(o.Weight) - 1; index >= 0; index-- {
-		// 输入层加权求和
+	// 前向
+	z, a, d := make([]*tensor.Tensor, len(o.Weight)), make([]*tensor.Tensor, len(o.Weight)), make([]*tensor.Tensor, len(o.Weight))
+	input := x
+	for index, bias := range o.Bias {
+		z[index], a[index], d[index] = ws.bz[index].Slice(0, 0, n), ws.ba[index].Slice(0, 0, n), ws.bd[index].Slice(0, 0, n)
+		z[index].Assign(tensor.FromSlice(bias, len(bias)))
+		tensor.Gemm(false, false, 1, input, o.weight(index), 1, z[index])
+		for s := 0; s < n; s++ {
+			o.activate(index, z[index].Row(s), a[index].Row(s))
+		}
+		input = a[index]
+	}
+
+	// 输出层残差
+	last := len(o.Weight) - 1
+	for s, index := range samples {
+		v := &o.Data[index]
+		y := a[last].Row(s)
+		o.outputDelta(y, z[last].Row(s), v.Output, d[last].Row(s))
+		diffs[s] = o.diff(y, v.Output)
+	}
+
+	// 反向
+	for index := last; index >= 0; index-- {
+		layer := x
+		if index > 0 {
+			layer = a[index-1]
+		}
+		tensor.Gemm(true, false, 1, layer, d[index], 1, g.w[index])
+		for s := 0; s < n; s++ {
+			tensor.Axpy(1, d[index].Row(s), g.b[index])
+		}
 		if index == 0 {
-			output1 = o.matrixMul2(output1, index, input)
+			break
+		}
+
+		tensor.Gemm(false, true, 1, d[index], o.weight(index), 0, d[index-1])
+		for s := 0; s < n; s++ {
+			o.derivative(index-1, z[index-1].Row(s), layer.Row(s), d[index-1].Row(s))
+		}
+	}
+}
+
+// 用n个样本累加的梯度修正权重和偏置，然后清空梯度
+func (o *NN) step(n int, learn float64) {
+	g := o.gradient()
+	if n > 1 {
+		g.scale(1 / float64(n))
+	}
+	o.clip(g, 1)
+	o.penalize(g, 1)
+	params, grads := o.params(g)
+	o.optimizer().Update(params, grads, learn)
+	o.constrain()
+	g.zero()
+}
+
+// 丢弃未修正的梯度
+func (o *NN) zeroGrad() {
+	if o.grad != nil {
+		o.grad.zero()
+	}
+}
+
+// 每层权重和偏置，以及对应的梯度，权重不连续时为每一行
+func (o *NN) params(g *gradient) (params, grads [][]Float) {
+	for k, v := range o.Weight {
+		if o.packed(k) {
+			params = append(params, o.w[k].Data)
+			grads = append(grads, g.w[k].Data)
 		} else {
-			output1 = o.matrixMul2(output1, index, o.Hidden[index-1])
+			params = append(params, v...)
+			grads = append(grads, g.w[k].Rows()...)
 		}
 	}
+	params = append(params, o.Bias...)
+	grads = append(grads, g.b...)
+	return
+}
 
-	// o.ll.Log0Debug("weight:", o.Weight)
+// 训练时累加的梯度
+func (o *NN) gradient() *gradient {
+	if o.grad == nil || !o.grad.fits(o) {
+		o.grad = newGradient(o)
+	}
+	return o.grad
+}
+
+// 权重和偏置的梯度
+type gradient struct {
+	w []*tensor.Tensor
+	b [][]Float
+}
+
+func newGradient(o *NN) *gradient {
+	g := &gradient{w: make([]*tensor.Tensor, len(o.Weight)), b: zerosLike(o.Bias)}
+	for k, v := range o.Weight {
+		g.w[k] = tensor.New(len(v), len(o.Bias[k]))
+	}
+	return g
+}
+
+// 是否与o的结构一致
+func (g *gradient) fits(o *NN) bool {
+	if len(g.w) != len(o.Weight) {
+		return false
+	}
+	for k, v := range o.Weight {
+		if g.w[k].Shape[0] != len(v) || g.w[k].Shape[1] != len(o.Bias[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *gradient) each(f func(v []Float)) {
+	for _, w := range g.w {
+		f(w.Data)
+	}
+	for _, v := range g.b {
+		f(v)
+	}
+}
+
+func (g *gradient) zero() {
+	g.each(func(v []Float) {
+		for k := range v {
+			v[k] = 0
+		}
+	})
+}
+
+func (g *gradient) scale(x float64) {
+	g.each(func(v []Float) { tensor.Scal(Float(x), v) })
+}
+
+// 累加src
+func (g *gradient) add(src *gradient) {
+	for k, w := range src.w {
+		tensor.Axpy(1, w.Data, g.w[k].Data)
+	}
+	for k, v := range src.b {
+		tensor.Axpy(1, v, g.b[k])
+	}
+}
+
+// 当前的学习率
+func (o *NN) learnRate(p Progress) float64 {
+	if o.Schedule != nil {
+		return o.Schedule.Rate(o.Learn, p)
+	}
+	return o.Learn
+}
+
+func (o *NN) optimizer() Optimizer {
+	if o.Optimizer != nil {
+		return o.Optimizer
+	}
+	return SGD{}
+}
+
+// 输出层误差对激活前的值的导数，y/z为输出层激活后/前的值
+func (o *NN) outputDelta(y, z, target, delta []Float) {
+	last := len(o.Weight) - 1
+	loss := o.loss()
+
+	if o.Softmax {
+		// softmax+交叉熵直接为y-t
+		if _, ok := loss.(CategoricalCrossEntropy); ok {
+			sum := tensor.Sum(target)
+			for k := range y {
+				delta[k] = y[k]*sum - target[k]
+			}
+			return
+		}
+
+		// softmax雅可比矩阵
+		loss.Gradient(y, target, delta)
+		dot := tensor.Dot(delta, y)
+		for k := range y {
+			delta[k] = y[k] * (delta[k] - dot)
+		}
+		return
+	}
+
+	act := o.activation(last)
+	// sigmoid+二元交叉熵直接为(y-t)/n，避免饱和时除0
+	if _, ok := loss.(BinaryCrossEntropy); ok {
+		if _, ok := act.(Sigmoid); ok {
+			for k := range y {
+				delta[k] = (y[k] - target[k]) / Float(len(y))
+			}
+			return
+		}
+	}
+	loss.Gradient(y, target, delta)
+	for k := range y {
+		delta[k] *= Float(act.Derivative(float64(z[k]), float64(y[k])))
+	}
+}
+
+// 训练用的损失函数
+func (o *NN) loss() Loss {
+	if o.Loss != nil {
+		return o.Loss
+	}
+	if o.Softmax {
+		return CategoricalCrossEntropy{}
+	}
+	return halfSquaredError{}
+}
+
+// 单个样本的误差，用于显示和判断是否达到MinDiff，默认为训练用的损失函数
+func (o *NN) diff(output, target []Float) float64 {
+	if o.TestCallback != nil {
+		return o.TestCallback(output, target)
+	}
+	return o.loss().Loss(output, target)
 }
 
 // Init ...
 func (o *NN) Init() error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
 	o.ll = logger.NewLogger(nil)
 	o.ll.SetFlags(log.Lshortfile)
 
@@ -151,8 +470,9 @@ func (o *NN) Init() error {
 	// fmt.Printf("name:%v | diff:%f | data: %v | count:%v | layer:%v\n", o.Name, o.MinDiff, len(o.Data), o.Count, o.Layer)
 
 	// generate hidden layer
+	o.Hidden = nil
 	for _, v := range o.Layer {
-		o.Hidden = append(o.Hidden, make([]float64, v))
+		o.Hidden = append(o.Hidden, make([]Float, v))
 	}
 	// o.ll.Log0Debug("hidden:", o.Hidden)
 
@@ -160,6 +480,10 @@ func (o *NN) Init() error {
 	if o.Weight == nil {
 		o.ResetWeight()
 	}
+	if o.Bias == nil {
+		o.resetBias()
+	}
+	o.pack()
 
 	// log.Println(o.Weight)
 	// log.Println(o.Bias)
@@ -177,136 +501,386 @@ func (o *NN) Init() error {
 	return nil
 }
 
-// ResetWeight ...
+// ResetWeight 按Initializers初始化权重，偏置为0
 func (o *NN) ResetWeight() {
-	o.Weight = make([][][]float64, 0)
+	o.Weight, o.w = make([][][]Float, 0), nil
 	// generate weight
 	tmp := []int{o.InputNum}
 	tmp = append(tmp, o.Layer...)
 	tmp = append(tmp, o.OutputNum)
 	for i := 0; i < len(tmp)-1; i++ {
-		t1 := make([][]float64, tmp[i])
-		for j := 0; j < tmp[i]; j++ {
-			t1[j] = make([]float64, tmp[i+1])
-			for m := 0; m < len(t1[j]); m++ {
-				if o.randFloat64(0.1, 0.9) < 0.5 {
-					t1[j][m] = o.randFloat64(0.1, 0.9)
-				} else {
-					t1[j][m] = -o.randFloat64(0.1, 0.9)
-				}
-			}
-		}
+		w := tensor.New(tmp[i], tmp[i+1])
+		t1 := w.Rows()
+		o.initializer(i).Init(t1, o.rand())
 		o.Weight = append(o.Weight, t1)
+		o.w = append(o.w, w)
 	}
+	o.resetBias()
 }
 
-// 数据归一
-func (o *NN) normalizing(data *[]StData) error {
-	// k=（b-a)/(Max-Min)
-	// Y=a+k(X-Min) 或者 Y=b+k(X-Max)
-	// X=(Y-a)/k+Min
-
-	// a/b/min/max/k
-	a, b := 0.0, 1.0
-	{ // 查找input最大最小值
-		min, max := (*data)[0].input[0]*2, (*data)[0].input[0]/2
-		for _, v := range *data {
-			for _, vv := range v.input {
-				if vv > max {
-					max = vv
-				}
-				if vv < min {
-					min = vv
-				}
-			}
-		}
-		if max == min {
-			return errors.New("min == max")
-		}
-		if min < a || max > b {
-			_k := (b - a) / (max - min)
-			for k := range *data {
-				for kk := range (*data)[k].input {
-					(*data)[k].input[kk] = a + _k*((*data)[k].input[kk]-min)
-				}
-			}
-		}
+// 第index层的权重初始化方法
+func (o *NN) initializer(index int) Initializer {
+	if index < len(o.Initializers) && o.Initializers[index] != nil {
+		return o.Initializers[index]
 	}
+	return legacyInit{}
+}
 
-	{ // 查找output最大最小值
-		min, max := (*data)[0].input[0]*2, (*data)[0].input[0]/2
-		for _, v := range *data {
-			for _, vv := range v.output {
-				if vv > max {
-					max = vv
-				}
-				if vv < min {
-					min = vv
-				}
-			}
-		}
-		if max == min {
-			return errors.New("min == max")
-		}
-		if min < a || max > b {
-			_k := (b - a) / (max - min)
-			for k := range *data {
-				for kk := range (*data)[k].output {
-					(*data)[k].output[kk] = a + _k*((*data)[k].output[kk]-min)
-				}
-			}
+// 偏置初始化为0
+func (o *NN) resetBias() {
+	o.Bias = make([][]Float, len(o.Weight))
+	for k, v := range o.Weight {
+		if len(v) > 0 {
+			o.Bias[k] = make([]Float, len(v[0]))
 		}
 	}
+}
 
+// 数据归一，第一次调用时从data中计算Normalization
+func (o *NN) normalizing(data *[]StData) error {
+	if o.Normalization == nil {
+		n, err := NewNormalization(*data)
+		if err != nil {
+			return err
+		}
+		o.Normalization = n
+	}
+	o.Normalization.Normalize(*data)
 	return nil
 }
 
+// TrainStats 训练统计
+type TrainStats struct {
+	Epoch    int           // 完成的训练轮数
+	Study    int           // 已学习的样本数
+	Steps    int           // 修正权重的次数
+	MaxDiff  float64       // 当前一轮的最大误差
+	Loss     float64       // 当前一轮的平均误差
+	Accuracy float64       // 最后一次检测的成功率
+	Learn    float64       // 当前的学习率
+	Duration time.Duration // 训练用时
+
+	ValLoss     float64 // 最后一轮验证集的平均误差
+	ValAccuracy float64 // 最后一轮验证集的成功率
+	BestEpoch   int     // 验证集指标最好的轮数，训练结束后恢复到这一轮的权重
+
+	Workers []TrainStats // 异步训练时每个goroutine的统计
+}
+
 // Train ...
 func (o *NN) Train() error {
+	_, err := o.TrainContext(context.Background())
+	return err
+}
+
+// TrainContext ctx取消或超时时在样本边界停止训练，未满一批的梯度被丢弃，
+// 返回最后一次修正权重时的统计和ctx.Err()
+func (o *NN) TrainContext(ctx context.Context) (stats TrainStats, err error) {
+	return o.trainFrom(ctx, nil)
+}
+
+// 从检查点的位置开始训练，state为nil时从头开始
+func (o *NN) trainFrom(ctx context.Context, state *TrainState) (stats TrainStats, err error) {
+	start := time.Now()
+	if state != nil {
+		stats = state.Stats
+		start = start.Add(-stats.Duration)
+	}
+	defer func() { stats.Duration = time.Since(start) }()
+
 	if err := o.Init(); err != nil {
-		return err
+		return stats, err
+	}
+	// 检查样本宽度
+	for _, data := range [][]StData{o.Data, o.Test, o.Validation} {
+		if err := (&Dataset{InputNum: o.InputNum, OutputNum: o.OutputNum, Data: data}).Validate(); err != nil {
+			return stats, err
+		}
+	}
+	if o.EarlyStop != nil {
+		if len(o.Validation) == 0 {
+			return stats, errors.New("EarlyStop needs Validation")
+		}
+		if err := o.EarlyStop.check(); err != nil {
+			return stats, err
+		}
+		o.EarlyStop.reset()
+		if state != nil {
+			o.EarlyStop.best, o.EarlyStop.bestEpoch, o.EarlyStop.wait = state.Best, state.BestEpoch, state.Wait
+			o.EarlyStop.weight, o.EarlyStop.bias = state.BestWeight, state.BestBias
+		}
+		defer o.EarlyStop.restore(o)
+	}
+	if o.Async {
+		if _, ok := o.optimizer().(SGD); !ok {
+			return stats, errors.New("Async only supports SGD")
+		}
 	}
 
 	all := o.Count * len(o.Data)
-	study := 0
-	diff := 0.0
-	max := o.MinDiff + 1
-	for count := 1; max > o.MinDiff && count <= o.Count; count++ {
-		max = 0
-		for k1 := range o.Data {
-			study++
-			diff = o.train(&o.Data[k1])
-			if diff > max {
-				max = diff
-			}
+	epoch, position := 0, 0
+	emit := func(typ EventType, reason string) {
+		if o.EventCallback == nil {
+			return
+		}
+		e := Event{Type: typ, Epoch: epoch, Epochs: o.Count, Study: stats.Study, Total: all, Step: stats.Steps,
+			Loss: stats.Loss, MaxDiff: stats.MaxDiff, Accuracy: stats.Accuracy, Learn: stats.Learn, ValLoss: stats.ValLoss, ValAccuracy: stats.ValAccuracy,
+			Elapsed: time.Since(start), Reason: reason}
+		if e.Elapsed > 0 {
+			e.Throughput = float64(e.Study) / e.Elapsed.Seconds()
+		}
+		if e.Throughput > 0 {
+			e.ETA = time.Duration(float64(all-e.Study) / e.Throughput * float64(time.Second))
+		}
+		o.EventCallback(e)
+	}
+
+	batch := o.batchSize()
+	evalEvery := o.evalEvery()
+	progress := Progress{Epochs: o.Count, Steps: o.Count * ((len(o.Data) + batch - 1) / batch)}
+	plateau, _ := o.Schedule.(metricSchedule)
+	parallel := o.Workers > 1 && batch > 1 // batch > 1时按矩阵计算整批样本
+	diffs := make([]float64, batch)
+	var order []int
+	max, sum := 0.0, 0.0
+	epoch = 1
+	keepOrder := false // 继续检查点中这一轮的顺序
+	if state != nil {
+		epoch, position, order, max, sum = state.Epoch, state.Position, state.Order, state.Max, state.Sum
+		keepOrder = order != nil
+		o.rand()
+		o.src.state = state.Rand
+	}
+
+	// 停止时的检查点从这一批的开始继续
+	checkpoint := o.newCheckpointer(ctx, start)
+	defer checkpoint.stop()
+	ctx = checkpoint.ctx
+	cancelled := func(err error, last TrainStats, position int) error {
+		return checkpoint.cancelled(err, &TrainState{Stats: last, Epoch: epoch, Position: position, Order: order, Max: max, Sum: sum})
+	}
 
+	// 出现NaN/Inf时回滚到这一轮开始时的位置，不能回滚时返回e
+	guard := o.Guard
+	if guard != nil {
+		guard.reset()
+	}
+	rollback := func(e *NumericError) error {
+		o.zeroGrad()
+		e.Epoch, e.Step = epoch, stats.Steps+1
+		if ok, err := guard.restore(o); err != nil {
+			return err
+		} else if !ok {
+			return e
+		}
+		last := guard.last
+		stats, position, max, sum = last.stats, last.position, last.max, last.sum
+		stats.Workers = append([]TrainStats(nil), last.stats.Workers...)
+		order, keepOrder = append(order[:0], last.order...), true
+		emit(EventRollback, e.Error())
+		return nil
+	}
+
+epochs:
+	for ; epoch <= o.Count; epoch++ {
+		emit(EventEpochStart, "")
+		if o.Async {
+			last := stats
+			if guard != nil {
+				if err := guard.save(o, stats, 0, nil, 0, 0); err != nil {
+					return stats, err
+				}
+			}
+			progress.Epoch, progress.Step = epoch-1, stats.Steps
+			stats.Learn = o.learnRate(progress)
+			if guard != nil {
+				stats.Learn *= guard.scale
+			}
+			if err := o.trainAsync(ctx, stats.Learn, &stats); err != nil {
+				return stats, cancelled(err, last, 0)
+			}
+			stats.Loss += o.penalty()
+			if guard != nil {
+				e := o.checkParams()
+				if e == nil && (math.IsNaN(stats.Loss) || math.IsInf(stats.Loss, 0)) {
+					e = &NumericError{What: "loss", Layer: -1, Sample: -1, Value: stats.Loss}
+				}
+				if e != nil {
+					if err := rollback(e); err != nil {
+						return stats, err
+					}
+					epoch--
+					continue epochs
+				}
+			}
 			if o.StudyCountCallback != nil {
-				o.StudyCountCallback(study)
+				o.StudyCountCallback(stats.Study)
+			}
+		} else {
+			if !keepOrder {
+				max, sum = 0, 0
+				order = o.order(order)
+			}
+			if guard != nil {
+				if err := guard.save(o, stats, position, order, max, sum); err != nil {
+					return stats, err
+				}
+			}
+			penalty := o.penalty() // 权重修正后重新计算
+			for k1 := position; k1 < len(order); k1 += batch {
+				samples := order[k1:]
+				if len(samples) > batch {
+					samples = samples[:batch]
+				}
+				last, lastMax, lastSum := stats, max, sum
+				if batch > 1 {
+					select {
+					case <-ctx.Done():
+						return last, cancelled(ctx.Err(), last, k1)
+					default:
+					}
+					if parallel {
+						o.trainParallel(samples, diffs)
+					} else {
+						o.trainBatch(o.workspace(), samples, diffs, o.gradient())
+					}
+				}
+
+				for k2, index := range samples {
+					select {
+					case <-ctx.Done():
+						o.zeroGrad()
+						max, sum = lastMax, lastSum
+						return last, cancelled(ctx.Err(), last, k1)
+					default:
+					}
+					if batch == 1 {
+						diffs[k2] = o.train(&o.Data[index])
+					}
+
+					diff := diffs[k2]
+					if diff > max {
+						max = diff
+					}
+					sum += diff
+					stats.Study++
+					stats.MaxDiff, stats.Loss = max, sum/float64(k1+k2+1)+penalty
+
+					// 累计batch个样本的梯度后再修正
+					if k2 == len(samples)-1 {
+						progress.Epoch, progress.Step = epoch-1, stats.Steps
+						stats.Learn = o.learnRate(progress)
+						var e *NumericError
+						if guard != nil {
+							stats.Learn *= guard.scale
+							e = o.checkStep(samples, diffs)
+						}
+						if e == nil {
+							o.step(len(samples), stats.Learn)
+							if guard != nil {
+								e = o.checkParams()
+							}
+						}
+						if e != nil {
+							if err := rollback(e); err != nil {
+								return stats, err
+							}
+							epoch--
+							continue epochs
+						}
+						stats.Steps++
+						penalty = o.penalty()
+						emit(EventStep, "")
+					}
+
+					if o.StudyCountCallback != nil {
+						o.StudyCountCallback(stats.Study)
+					}
+				}
+
+				if checkpoint.due(0) {
+					if err := checkpoint.save(&TrainState{Stats: stats, Epoch: epoch, Position: k1 + len(samples), Order: order, Max: max, Sum: sum}); err != nil {
+						return stats, err
+					}
+				}
+			}
+			position, keepOrder = 0, false
+		}
+		if guard != nil {
+			guard.rollbacks = 0
+		}
+		stats.Epoch = epoch
+
+		if (len(o.Test) > 0 || o.CheckCallback != nil) && (epoch%evalEvery == 0 || epoch == o.Count || stats.MaxDiff <= o.MinDiff) {
+			stats.Accuracy = o.Check(false, false)
+			emit(EventEvaluate, "")
+		}
+		if len(o.Validation) > 0 && (o.EarlyStop != nil || plateau != nil) {
+			stats.ValLoss, stats.ValAccuracy = o.Evaluate(o.Validation)
+		}
+		if plateau != nil {
+			if len(o.Validation) > 0 {
+				plateau.Observe(stats.ValLoss)
+			} else {
+				plateau.Observe(stats.Loss)
 			}
-			if study%(all/1000) == 0 {
-				percent := o.Check(false, false)
-				fmt.Printf("\r训练：%v/%v(%.1f%%) | 误差：%0.8f | 成功率：%.2f%%", study, all, float64(study)/float64(all)*100, max, percent*100)
+		}
+		emit(EventEpochEnd, "")
+
+		if stats.MaxDiff <= o.MinDiff {
+			if epoch < o.Count {
+				emit(EventEarlyStop, "MinDiff")
 			}
-			if study%(all/10) == 0 {
-				fmt.Println()
+			break
+		}
+		if o.EarlyStop != nil && o.EarlyStop.update(o, epoch, stats.ValLoss, stats.ValAccuracy) {
+			if epoch < o.Count {
+				emit(EventEarlyStop, "EarlyStop")
 			}
+			break
 		}
+		if epoch < o.Count && checkpoint.due(epoch) {
+			if err := checkpoint.save(&TrainState{Stats: stats, Epoch: epoch + 1}); err != nil {
+				return stats, err
+			}
+		}
+	}
+	epoch = stats.Epoch
+	if o.EarlyStop != nil {
+		stats.BestEpoch = o.EarlyStop.bestEpoch
 	}
-	fmt.Println()
-	fmt.Println("学习次数:", study)
+	emit(EventTrainEnd, "")
 
-	return nil
+	return stats, nil
+}
+
+// 每几轮检测一次
+func (o *NN) evalEvery() int {
+	if o.EvalEvery > 0 {
+		return o.EvalEvery
+	}
+	if o.Count >= 1000 {
+		return o.Count / 1000
+	}
+	return 1
 }
 
 func (o *NN) train(v *StData) float64 {
 	runtime.Gosched()
-	o.Right(v.input)
-	o.Left(v.input, v.output)
+	o.Right(v.Input)
+	o.backward(v.Input, v.Output, o.ws, o.gradient())
 
-	if o.TestCallback != nil {
-		return o.TestCallback(o.Output, v.output)
+	return o.diff(o.Output, v.Output)
+}
+
+func (o *NN) batchSize() int {
+	if o.BatchSize == 0 || len(o.Data) == 0 {
+		return 1
+	}
+	if o.BatchSize < 0 || o.BatchSize > len(o.Data) {
+		return len(o.Data)
 	}
-	return o.defaultCheckFun(o.Output, v.output)
+	return o.BatchSize
 }
 
 // Check ...
@@ -322,18 +896,14 @@ func (o *NN) defaultCheck(showLog bool, showPercent bool) float64 {
 	b := 0.0
 	for _, v := range o.Test {
 		chk++
-		o.Right(v.input)
-		if o.TestCallback != nil {
-			b = o.TestCallback(o.Output, v.output)
-		} else {
-			b = o.defaultCheckFun(o.Output, v.output)
-		}
+		o.Right(v.Input)
+		b = o.diff(o.Output, v.Output)
 
 		if b < o.MinDiff {
 			success++
 		}
 		if showLog {
-			fmt.Printf("\r检测:%v | 期望：%0.8f | 结果：%0.8f | 误差：%0.8f   ", b < o.MinDiff, v.output, o.Output, b)
+			fmt.Printf("\r检测:%v | 期望：%0.8f | 结果：%0.8f | 误差：%0.8f   ", b < o.MinDiff, v.Output, o.Output, b)
 		}
 	}
 	percent := float64(success) / float64(chk)
@@ -343,25 +913,12 @@ func (o *NN) defaultCheck(showLog bool, showPercent bool) float64 {
 	return percent
 }
 
-func (o NN) defaultCheckFun(chk, result []float64) float64 {
-	sum := 0.0
-	for k := range chk {
-		sum += math.Pow(chk[k]-result[k], 2)
-	}
-	return math.Sqrt(sum / float64(len(chk)))
-}
-
-// mrand "math/rand"
 func (o *NN) randFloat64(min, max float64) float64 {
 	if min == 0 && max == 0 {
 		return 0
 	}
-	if o.Seed {
-		o.Seed = false
-		mrand.Seed(time.Now().UnixNano())
-	}
 	for {
-		x := mrand.Float64()*(max-min) + min
+		x := o.rand().Float64()*(max-min) + min
 		if x != 0 {
 			return x
 		}
@@ -386,9 +943,16 @@ func (o *NN) perLevelNode3(m, n int) int {
 	return int(math.Ceil(math.Sqrt(0.43*float64(m)*float64(n)+0.12*float64(n)*float64(n)+2.54*float64(m)+0.77*float64(n)+0.35) + 0.51))
 }
 
+// 权重文件格式
+type stWeight struct {
+	Weight    [][][]Float
+	Bias      [][]Float
+	Precision string `json:",omitempty"` // 保存时的精度，读取时按当前精度转换
+}
+
 // SaveWeight ...
 func (o *NN) SaveWeight(fileName string) error {
-	bs, err := json.Marshal(o.Weight)
+	bs, err := json.Marshal(stWeight{Weight: o.Weight, Bias: o.Bias, Precision: Precision})
 	if err != nil {
 		return err
 	}
@@ -406,14 +970,36 @@ func (o *NN) LoadWeight(fileName string) error {
 
 // ToJSON ...
 func (o *NN) ToJSON() string {
-	bs, _ := json.Marshal(o.Weight)
+	bs, _ := json.Marshal(stWeight{Weight: o.Weight, Bias: o.Bias, Precision: Precision})
 	return string(bs)
 }
 
-// FromJSON ...
+// FromJSON 兼容旧格式（只有权重的数组），形状与InputNum、Layer和OutputNum不同时返回ErrShapeMismatch，不修改NN
 func (o *NN) FromJSON(str string) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	w := stWeight{}
+	str = strings.TrimSpace(str)
+	if strings.HasPrefix(str, "[") {
+		if err := json.Unmarshal([]byte(str), &w.Weight); err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal([]byte(str), &w); err != nil {
+		return err
+	}
+	if err := checkWeight(o.sizes(), w.Weight, w.Bias); err != nil {
+		return err
+	}
 	if err := o.Init(); err != nil {
 		return err
 	}
-	return json.Unmarshal([]byte(str), &o.Weight)
+
+	o.Weight = w.Weight
+	o.Bias = w.Bias
+	if o.Bias == nil {
+		o.resetBias()
+	}
+	o.pack()
+	return nil
 }
@@ -1,11 +1,25 @@
 package nn
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io/ioutil"
 	"log"
 	"math"
+	mrand "math/rand"
 	"nn/mnist"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // go test nn -run Test_开发 -v -count=1 -timeout=1h
@@ -13,18 +27,18 @@ func Test_开发(t *testing.T) {
 	o := &NN{
 		Name: "开发", Learn: 0.6, MinDiff: math.Pow(0.01, 2), Count: 1000,
 		Data: []StData{
-			{input: []float64{0.1, 0.2}, output: []float64{0.3, 0.4}},
+			{Input: []Float{0.1, 0.2}, Output: []Float{0.3, 0.4}},
 		},
 		InputNum: 2, OutputNum: 2,
 		Layer: []int{3, 3},
 		Test: []StData{
-			{input: []float64{0.3, 0.3}, output: []float64{0.6, 0.7}},
-			{input: []float64{0.1, 0.1}, output: []float64{0.9, 0.8}},
+			{Input: []Float{0.3, 0.3}, Output: []Float{0.6, 0.7}},
+			{Input: []Float{0.1, 0.1}, Output: []Float{0.9, 0.8}},
 		},
-		Weight: [][][]float64{
-			{[]float64{0.2, 0.3, 0.4}, []float64{0.5, 0.6, 0.7}},
-			{[]float64{0.1, 0.2, 0.3}, []float64{0.4, 0.5, 0.6}, []float64{0.7, 0.8, 0.9}},
-			{[]float64{0.2, 0.4}, []float64{0.6, 0.8}, []float64{0.3, 0.5}},
+		Weight: [][][]Float{
+			{[]Float{0.2, 0.3, 0.4}, []Float{0.5, 0.6, 0.7}},
+			{[]Float{0.1, 0.2, 0.3}, []Float{0.4, 0.5, 0.6}, []Float{0.7, 0.8, 0.9}},
+			{[]Float{0.2, 0.4}, []Float{0.6, 0.8}, []Float{0.3, 0.5}},
 		},
 	}
 
@@ -41,19 +55,19 @@ func Test_教程样本(t *testing.T) {
 	o := &NN{
 		Name: "教程样本", Learn: 0.6, MinDiff: math.Pow(0.01, 2), Count: 1000,
 		Data: []StData{
-			{input: []float64{0.4, -0.7}, output: []float64{0.1}},
-			{input: []float64{0.3, -0.5}, output: []float64{0.05}},
-			{input: []float64{0.6, 0.1}, output: []float64{0.3}},
-			{input: []float64{0.2, 0.4}, output: []float64{0.25}},
+			{Input: []Float{0.4, -0.7}, Output: []Float{0.1}},
+			{Input: []Float{0.3, -0.5}, Output: []Float{0.05}},
+			{Input: []Float{0.6, 0.1}, Output: []Float{0.3}},
+			{Input: []Float{0.2, 0.4}, Output: []Float{0.25}},
 		},
 		InputNum: 2, OutputNum: 1,
 		Layer: []int{2},
 		Test: []StData{
-			{input: []float64{0.1, -0.2}, output: []float64{0.12}},
+			{Input: []Float{0.1, -0.2}, Output: []Float{0.12}},
 		},
-		Weight: [][][]float64{
-			{[]float64{0.1, 0.4}, []float64{-0.2, 0.2}},
-			{[]float64{0.2}, []float64{-0.5}},
+		Weight: [][][]Float{
+			{[]Float{0.1, 0.4}, []Float{-0.2, 0.2}},
+			{[]Float{0.2}, []Float{-0.5}},
 		},
 	}
 
@@ -69,17 +83,17 @@ func Test_01(t *testing.T) {
 		Name: "1", Learn: 0.6, MinDiff: math.Pow(0.1, 2), Count: 100000,
 		InputNum: 2, OutputNum: 1,
 		Data: []StData{
-			{input: []float64{0, 0}, output: []float64{0}},
-			{input: []float64{0, 1}, output: []float64{1}},
-			{input: []float64{1, 0}, output: []float64{1}},
-			{input: []float64{1, 1}, output: []float64{0}},
+			{Input: []Float{0, 0}, Output: []Float{0}},
+			{Input: []Float{0, 1}, Output: []Float{1}},
+			{Input: []Float{1, 0}, Output: []Float{1}},
+			{Input: []Float{1, 1}, Output: []Float{0}},
 		},
 		Layer: []int{6},
 		Test: []StData{
-			{input: []float64{0, 0}, output: []float64{0}},
-			{input: []float64{0, 1}, output: []float64{1}},
-			{input: []float64{1, 0}, output: []float64{1}},
-			{input: []float64{1, 1}, output: []float64{0}},
+			{Input: []Float{0, 0}, Output: []Float{0}},
+			{Input: []Float{0, 1}, Output: []Float{1}},
+			{Input: []Float{1, 0}, Output: []Float{1}},
+			{Input: []Float{1, 1}, Output: []Float{0}},
 		},
 	}
 
@@ -94,17 +108,18 @@ func Test_加法(t *testing.T) {
 	o := &NN{
 		Name: "加法", Learn: 0.6, MinDiff: math.Pow(0.03, 2), Count: 100000,
 		InputNum: 2, OutputNum: 1,
-		Layer: []int{3, 3, 3},
+		Layer:         []int{3, 3, 3},
+		EventCallback: ConsoleReporter(os.Stdout),
 	}
 
 	for i := 0; i < 1000; i++ {
-		x, y := o.randFloat64(0.1, 0.49), o.randFloat64(0.1, 0.49)
-		o.Data = append(o.Data, StData{input: []float64{x, y}, output: []float64{x + y}})
+		x, y := Float(o.randFloat64(0.1, 0.49)), Float(o.randFloat64(0.1, 0.49))
+		o.Data = append(o.Data, StData{Input: []Float{x, y}, Output: []Float{x + y}})
 	}
 
 	for i := 0; i < 1000; i++ {
-		x, y := o.randFloat64(0.1, 0.49), o.randFloat64(0.1, 0.49)
-		o.Test = append(o.Test, StData{input: []float64{x, y}, output: []float64{x + y}})
+		x, y := Float(o.randFloat64(0.1, 0.49)), Float(o.randFloat64(0.1, 0.49))
+		o.Test = append(o.Test, StData{Input: []Float{x, y}, Output: []Float{x + y}})
 	}
 
 	o.Train()
@@ -114,60 +129,44 @@ func Test_加法(t *testing.T) {
 // go test nn -run Test_Mnist -v -count=1 -timeout=1h
 func Test_Mnist(t *testing.T) {
 	o := &NN{
-		Name: "MNIST", Learn: 0.6, MinDiff: math.Pow(0.01, 2), Count: 3,
+		Name: "MNIST", Learn: 0.1, MinDiff: math.Pow(0.01, 2), Count: 3,
 		InputNum: 28 * 28, OutputNum: 10,
-		Data:  []StData{},
-		Layer: []int{10},
+		Layer:         []int{10},
+		Softmax:       true,
+		EventCallback: ConsoleReporter(os.Stdout),
 	}
 
-	{ // 训练数据
-		dataSet, err := mnist.ReadTrainSet("./mnist/MNIST_data")
+	// 读取MNIST
+	read := func(dataSet *mnist.DataSet, err error) []StData {
 		if err != nil {
-			log.Fatal(err)
+			t.Skip(err)
 		}
 
-		log.Printf("MNISST train: N:%v | W:%v | H:%v", dataSet.N, dataSet.W, dataSet.H)
+		log.Printf("MNISST: N:%v | W:%v | H:%v", dataSet.N, dataSet.W, dataSet.H)
 
+		ds := NewDataset(o.InputNum, o.OutputNum)
 		for _, v := range dataSet.Data {
-			bits := make([]float64, dataSet.W*dataSet.H)
+			bits := make([]Float, dataSet.W*dataSet.H)
 			pos := 0
 			for _, vv := range v.Image {
 				for _, vvv := range vv {
-					bits[pos] = float64(vvv) / 0xff
+					bits[pos] = Float(vvv) / 0xff
 					pos++
 				}
 			}
-			out := make([]float64, 10)
+			out := make([]Float, 10)
 			out[v.Digit] = 1
-			o.Data = append(o.Data, StData{input: bits, output: out})
-		}
-	}
-
-	{ // 测试数据
-		dataSet, err := mnist.ReadTestSet("./mnist/MNIST_data")
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		log.Printf("MNISST test: N:%v | W:%v | H:%v", dataSet.N, dataSet.W, dataSet.H)
-
-		for _, v := range dataSet.Data {
-			bits := make([]float64, dataSet.W*dataSet.H)
-			pos := 0
-			for _, vv := range v.Image {
-				for _, vvv := range vv {
-					bits[pos] = float64(vvv) / 0xff
-					pos++
-				}
+			if err := ds.Append(bits, out); err != nil {
+				t.Fatal(err)
 			}
-			out := make([]float64, 10)
-			out[v.Digit] = 1
-			o.Test = append(o.Test, StData{input: bits, output: out})
 		}
+		return ds.Data
 	}
+	o.Data = read(mnist.ReadTrainSet("./mnist/MNIST_data")) // 训练数据
+	o.Test = read(mnist.ReadTestSet("./mnist/MNIST_data"))  // 测试数据
 
-	max := func(data []float64) (int, float64) {
-		mi, mv := 0, 0.0
+	max := func(data []Float) (int, Float) {
+		mi, mv := 0, Float(0)
 		for k, v := range data {
 			if v > mv {
 				mi = k
@@ -182,9 +181,9 @@ func Test_Mnist(t *testing.T) {
 		b := 0.0
 		for _, v := range o.Test {
 			chk++
-			output := o.Right(v.input)
+			output := o.Right(v.Input)
 			ri, rv := max(output)
-			ci, cv := max(v.output)
+			ci, cv := max(v.Output)
 
 			if ri == ci {
 				success++
@@ -216,7 +215,7 @@ func Test_Mnist(t *testing.T) {
 }
 
 func Test_sigmoid(t *testing.T) {
-	log.Println(sigmoid([]float64{
+	log.Println(sigmoid([]Float{
 		0, 1, 10, -10,
 		0.001, 0.1, 0.9, 0.99, 1,
 		-0.001, -0.1, -0.9, -0.999, -1}))
@@ -227,3 +226,1652 @@ func Test_randFloat64(t *testing.T) {
 		log.Println((&NN{Seed: true}).randFloat64(0.1, 0.9))
 	}
 }
+
+// go test nn -run Test_偏置 -v -count=1
+func Test_偏置(t *testing.T) {
+	// 输入全为0时，没有偏置只能输出sigmoid(0)=0.5
+	o := &NN{
+		Name: "偏置", Learn: 0.6, MinDiff: math.Pow(0.01, 2), Count: 2000,
+		InputNum: 1, OutputNum: 1,
+		Layer: []int{2},
+		Data: []StData{
+			{Input: []Float{0}, Output: []Float{0.8}},
+		},
+	}
+	if err := o.Train(); err != nil {
+		t.Fatal(err)
+	}
+	if out := o.Right([]Float{0})[0]; math.Abs(float64(out)-0.8) > 0.05 {
+		t.Fatal("bias not trained:", out)
+	}
+
+	// 保存/读取偏置，兼容只有权重的旧格式
+	n := &NN{InputNum: 1, OutputNum: 1, Layer: []int{2}}
+	if err := n.FromJSON(o.ToJSON()); err != nil {
+		t.Fatal(err)
+	}
+	if n.Right([]Float{0})[0] != o.Right([]Float{0})[0] {
+		t.Fatal("bias not restored")
+	}
+	if err := n.FromJSON(`[[[0.1,0.2]],[[0.3],[0.4]]]`); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Bias) != 2 || len(n.Bias[0]) != 2 || n.Bias[1][0] != 0 {
+		t.Fatal("legacy weight bias:", n.Bias)
+	}
+}
+
+// go test nn -run Test_激活函数 -v -count=1
+func Test_激活函数(t *testing.T) {
+	acts := []Activation{Sigmoid{}, Tanh{}, ReLU{}, LeakyReLU{}, ELU{}, Softplus{}, Identity{}}
+	for _, act := range acts {
+		// 与数值导数比较
+		for _, x := range []float64{-2, -0.5, 0.3, 1.7} {
+			h := 1e-6
+			want := (act.Forward(x+h) - act.Forward(x-h)) / (2 * h)
+			got := act.Derivative(x, act.Forward(x))
+			if math.Abs(want-got) > 1e-5 {
+				t.Fatalf("%T'(%v): want %v got %v", act, x, want, got)
+			}
+		}
+	}
+	if v := (Softplus{}).Forward(1000); v != 1000 {
+		t.Fatal("softplus overflow:", v)
+	}
+}
+
+// go test nn -run Test_线性输出 -v -count=1
+func Test_线性输出(t *testing.T) {
+	// 目标值超出(0,1)，sigmoid输出无法拟合
+	o := &NN{
+		Name: "线性输出", Learn: 0.01, MinDiff: math.Pow(0.01, 2), Count: 2000,
+		InputNum: 1, OutputNum: 1,
+		Layer:       []int{4},
+		Activations: []Activation{Tanh{}, Identity{}},
+	}
+	for i := 0; i < 10; i++ {
+		x := Float(i) / 10
+		o.Data = append(o.Data, StData{Input: []Float{x}, Output: []Float{3*x - 1}})
+	}
+	if err := o.Train(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range o.Data {
+		if out := o.Right(v.Input)[0]; math.Abs(float64(out-v.Output[0])) > 0.1 {
+			t.Fatal("identity output:", v.Input, v.Output, out)
+		}
+	}
+}
+
+// go test nn -run Test_Softmax -v -count=1
+func Test_Softmax(t *testing.T) {
+	y := make([]Float, 3)
+	softmax([]Float{1000, 1000, 999}, y)
+	if math.IsNaN(float64(y[0])) || math.Abs(float64(y[0]+y[1]+y[2]-1)) > 1e-6 || y[0] != y[1] || y[2] >= y[0] {
+		t.Fatal("softmax:", y)
+	}
+
+	o := &NN{
+		Name: "Softmax", Learn: 0.1, MinDiff: math.Pow(0.01, 2), Count: 2000,
+		InputNum: 2, OutputNum: 3,
+		Layer:   []int{6},
+		Softmax: true,
+		Data: []StData{
+			{Input: []Float{0, 0}, Output: []Float{1, 0, 0}},
+			{Input: []Float{0, 1}, Output: []Float{0, 1, 0}},
+			{Input: []Float{1, 0}, Output: []Float{0, 1, 0}},
+			{Input: []Float{1, 1}, Output: []Float{0, 0, 1}},
+		},
+	}
+	if err := o.Train(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range o.Data {
+		out := o.Right(v.Input)
+		sum := 0.0
+		for k := range out {
+			sum += float64(out[k])
+			if v.Output[k] == 1 && out[k] < 0.8 {
+				t.Fatal("softmax classify:", v.Input, out)
+			}
+		}
+		if math.Abs(sum-1) > tolerance(1e-9) {
+			t.Fatal("softmax sum:", sum)
+		}
+	}
+}
+
+// go test nn -run Test_损失函数 -v -count=1
+func Test_损失函数(t *testing.T) {
+	output, target := []Float{0.2, 0.7, 0.1}, []Float{0, 1, 0}
+	losses := []Loss{MSE{}, MAE{}, Huber{Delta: 0.25}, BinaryCrossEntropy{}, CategoricalCrossEntropy{}, Quantile{Tau: 0.9}, halfSquaredError{}}
+	for _, loss := range losses {
+		// 与数值导数比较
+		grad := make([]Float, len(output))
+		loss.Gradient(output, target, grad)
+		for k := range output {
+			h, tol := Float(1e-6), 1e-5
+			if Precision == "float32" {
+				h, tol = 1e-3, 1e-3
+			}
+			x := output[k]
+			output[k] = x + h
+			l1 := loss.Loss(output, target)
+			output[k] = x - h
+			l2 := loss.Loss(output, target)
+			output[k] = x
+			if want := (l1 - l2) / float64((x+h)-(x-h)); math.Abs(want-float64(grad[k])) > tol {
+				t.Fatalf("%T[%d]: want %v got %v", loss, k, want, grad[k])
+			}
+		}
+	}
+
+	// 训练时的误差即为报告的误差
+	o := &NN{
+		Name: "损失函数", Learn: 0.1, MinDiff: 0.01, Count: 3000,
+		InputNum: 1, OutputNum: 1,
+		Layer:       []int{4},
+		Activations: []Activation{Tanh{}, Identity{}},
+		Loss:        Huber{},
+		Data: []StData{
+			{Input: []Float{0}, Output: []Float{2}},
+			{Input: []Float{1}, Output: []Float{-1}},
+		},
+	}
+	if err := o.Train(); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range o.Data {
+		if l := o.Loss.Loss(o.Right(v.Input), v.Output); l > 0.01 {
+			t.Fatal("huber loss:", l)
+		}
+	}
+
+	// 没有Loss时报告默认的损失函数，Softmax时为交叉熵
+	for _, softmax := range []bool{false, true} {
+		o := &NN{InputNum: 2, OutputNum: 2, Layer: []int{3}, Softmax: softmax}
+		o.Init()
+		y := o.Right([]Float{0.3, 0.6})
+		if got, want := o.diff(y, target[:2]), o.loss().Loss(y, target[:2]); got != want {
+			t.Fatal("diff:", softmax, got, want)
+		}
+	}
+}
+
+// go test nn -run Test_优化器 -v -count=1
+func Test_优化器(t *testing.T) {
+	optimizers := []struct {
+		opt   Optimizer
+		learn float64
+	}{
+		{SGD{}, 0.1},
+		{&Momentum{}, 0.02},
+		{&Momentum{Nesterov: true}, 0.02},
+		{&AdaGrad{}, 0.1},
+		{&RMSProp{}, 0.01},
+		{&Adam{}, 0.01},
+		{&AdamW{}, 0.01},
+	}
+	for _, v := range optimizers {
+		o := &NN{
+			Name: "优化器", Learn: v.learn, MinDiff: 1e-6, Count: 500,
+			InputNum: 1, OutputNum: 1,
+			Layer:       []int{4},
+			Activations: []Activation{Tanh{}, Identity{}},
+			Optimizer:   v.opt,
+			Loss:        MSE{},
+			Weight:      [][][]Float{{{0.5, -0.3, 0.2, 0.7}}, {{0.1}, {-0.4}, {0.6}, {0.3}}},
+		}
+		for i := 0; i < 10; i++ {
+			x := Float(i) / 10
+			o.Data = append(o.Data, StData{Input: []Float{x}, Output: []Float{2*x - 1}})
+		}
+		if err := o.Train(); err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range o.Data {
+			if l := o.Loss.Loss(o.Right(d.Input), d.Output); l > 0.01 {
+				t.Fatalf("%T: loss %v", v.opt, l)
+			}
+		}
+	}
+}
+
+// go test nn -run Test_批量 -v -count=1
+func Test_批量(t *testing.T) {
+	newNN := func(batch int) *NN {
+		o := &NN{
+			Name: "批量", Learn: 0.5, MinDiff: 1e-9, Count: 1,
+			InputNum: 2, OutputNum: 1,
+			Layer:     []int{2},
+			BatchSize: batch,
+			Weight:    [][][]Float{{{0.1, 0.4}, {-0.2, 0.2}}, {{0.2}, {-0.5}}},
+			Data: []StData{
+				{Input: []Float{0.4, -0.7}, Output: []Float{0.1}},
+				{Input: []Float{0.3, -0.5}, Output: []Float{0.05}},
+				{Input: []Float{0.6, 0.1}, Output: []Float{0.3}},
+			},
+		}
+		o.Init()
+		return o
+	}
+
+	// 全批量SGD修正量等于每个样本单独修正量的平均值
+	full := newNN(-1)
+	for _, d := range full.Data {
+		full.Right(d.Input)
+		full.backward(d.Input, d.Output, full.workspace(), full.gradient())
+	}
+	full.step(len(full.Data), full.Learn)
+	want := newNN(0)
+	for _, d := range want.Data {
+		o := newNN(0)
+		o.Right(d.Input)
+		o.Left(d.Input, d.Output)
+		for k1 := range o.Weight {
+			for k2 := range o.Weight[k1] {
+				for k3 := range o.Weight[k1][k2] {
+					want.Weight[k1][k2][k3] += (o.Weight[k1][k2][k3] - newNN(0).Weight[k1][k2][k3]) / 3
+				}
+			}
+		}
+	}
+	for k1 := range want.Weight {
+		for k2 := range want.Weight[k1] {
+			for k3 := range want.Weight[k1][k2] {
+				if math.Abs(float64(want.Weight[k1][k2][k3]-full.Weight[k1][k2][k3])) > tolerance(1e-12) {
+					t.Fatal("full batch:", want.Weight, full.Weight)
+				}
+			}
+		}
+	}
+
+	// 最后不足一批的样本也要修正
+	o := newNN(2)
+	var steps int
+	o.Optimizer = countOptimizer{&steps}
+	o.Count = 1000
+	o.Train()
+	if steps != 2*1000 {
+		t.Fatal("batch steps:", steps)
+	}
+
+	// 没有样本
+	for _, batch := range []int{-1, 32} {
+		o := &NN{InputNum: 2, OutputNum: 1, Layer: []int{2}, Count: 3, BatchSize: batch}
+		if err := o.Train(); err != nil {
+			t.Fatal("empty data:", batch, err)
+		}
+	}
+}
+
+type countOptimizer struct{ n *int }
+
+func (o countOptimizer) Update(params, grads [][]Float, learn float64) { *o.n++ }
+
+// go test nn -run Test_随机种子 -v -count=1
+func Test_随机种子(t *testing.T) {
+	newNN := func(seed int64) *NN {
+		o := &NN{
+			Name: "随机种子", Learn: 0.6, MinDiff: 1e-9, Count: 1000,
+			InputNum: 2, OutputNum: 1,
+			Layer:    []int{4},
+			RandSeed: seed,
+			Shuffle:  true,
+			Data: []StData{
+				{Input: []Float{0, 0}, Output: []Float{0}},
+				{Input: []Float{0, 1}, Output: []Float{1}},
+				{Input: []Float{1, 0}, Output: []Float{1}},
+				{Input: []Float{1, 1}, Output: []Float{0}},
+			},
+		}
+		if err := o.Train(); err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+
+	// 相同种子，权重完全相同
+	if a, b := newNN(7).ToJSON(), newNN(7).ToJSON(); a != b {
+		t.Fatal("same seed:", a, b)
+	}
+	if a, b := newNN(7).ToJSON(), newNN(8).ToJSON(); a == b {
+		t.Fatal("different seed:", a)
+	}
+	// 未设置种子的两个NN互不影响
+	if a, b := newNN(0).ToJSON(), newNN(0).ToJSON(); a == b {
+		t.Fatal("zero seed:", a)
+	}
+}
+
+// go test nn -run Test_样本集 -v -count=1
+func Test_样本集(t *testing.T) {
+	ds := NewDataset(2, 1)
+	if err := ds.Append([]Float{0.1, 0.2}, []Float{0.3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Append([]Float{1.0 / 3, -2e-10}, []Float{1e20}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Append([]Float{0.1}, []Float{0.3}); err == nil {
+		t.Fatal("input width not checked")
+	}
+	if err := ds.Append([]Float{0.1, 0.2}, []Float{0.3, 0.4}); err == nil {
+		t.Fatal("output width not checked")
+	}
+	if ds.Len() != 2 {
+		t.Fatal("len:", ds.Len())
+	}
+	if _, err := NewDatasetFrom([][]Float{{1, 2}, {3}}, [][]Float{{1}, {2}}); err == nil {
+		t.Fatal("NewDatasetFrom width not checked")
+	}
+
+	// JSON
+	ds2, err := DatasetFromJSON(ds.ToJSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds2.ToJSON() != ds.ToJSON() {
+		t.Fatal("json:", ds2.ToJSON())
+	}
+
+	// CSV
+	buf := &bytes.Buffer{}
+	if err := ds.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	ds3, err := ReadCSV(buf, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds3.ToJSON() != ds.ToJSON() {
+		t.Fatal("csv:", ds3.ToJSON())
+	}
+	if _, err := ReadCSV(strings.NewReader("1,2\n"), 2, 1); err == nil {
+		t.Fatal("csv width not checked")
+	}
+	for _, input := range [][]Float{{1, 2}, {1, 2, 5}} {
+		bad := &Dataset{InputNum: 1, OutputNum: 1, Data: []StData{{Input: input, Output: []Float{3}}}}
+		buf.Reset()
+		if err := bad.WriteCSV(buf); !errors.Is(err, ErrShapeMismatch) || buf.Len() != 0 {
+			t.Fatal("csv write width not checked:", err, buf.String())
+		}
+	}
+
+	// 训练前检查样本宽度
+	o := &NN{InputNum: 2, OutputNum: 2, Layer: []int{2}, Data: ds.Data}
+	if err := o.Train(); err == nil {
+		t.Fatal("train width not checked")
+	}
+}
+
+// go test nn -run Test_取消训练 -v -count=1
+func Test_取消训练(t *testing.T) {
+	newNN := func() *NN {
+		return &NN{
+			Name: "取消训练", Learn: 0.6, MinDiff: 1e-9, Count: 1000000,
+			InputNum: 2, OutputNum: 1,
+			Layer:     []int{4},
+			BatchSize: 3,
+			Data: []StData{
+				{Input: []Float{0, 0}, Output: []Float{0}},
+				{Input: []Float{0, 1}, Output: []Float{1}},
+				{Input: []Float{1, 0}, Output: []Float{1}},
+				{Input: []Float{1, 1}, Output: []Float{0}},
+			},
+		}
+	}
+
+	// 超时
+	o := newNN()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stats, err := o.TrainContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatal("err:", err)
+	}
+	// 超时从创建ctx开始计时，比TrainContext早开始，留出余量
+	if stats.Study == 0 || stats.Epoch == 0 || stats.Duration < 40*time.Millisecond || stats.Duration > time.Second {
+		t.Fatal("stats:", stats)
+	}
+
+	// 在批次中间取消，未修正的梯度被丢弃，统计停在这一批的开始
+	o = newNN()
+	ctx, cancel = context.WithCancel(context.Background())
+	o.StudyCountCallback = func(study int) {
+		if study == 5 {
+			cancel()
+		}
+	}
+	stats, err = o.TrainContext(ctx)
+	if err != context.Canceled || stats.Study != 4 || stats.Steps != 2 || stats.Epoch != 1 {
+		t.Fatal("cancel:", stats, err)
+	}
+	for _, g := range o.grad.b {
+		for _, v := range g {
+			if v != 0 {
+				t.Fatal("gradient not dropped:", o.grad.b)
+			}
+		}
+	}
+}
+
+// go test nn -run Test_训练事件 -v -count=1
+func Test_训练事件(t *testing.T) {
+	var events []Event
+	o := &NN{
+		Name: "训练事件", Learn: 0.6, MinDiff: 1e-9, Count: 3,
+		InputNum: 1, OutputNum: 1,
+		Layer:         []int{2},
+		BatchSize:     2,
+		Data:          []StData{{Input: []Float{0}, Output: []Float{0.8}}, {Input: []Float{1}, Output: []Float{0.2}}, {Input: []Float{0.5}, Output: []Float{0.5}}},
+		Test:          []StData{{Input: []Float{0}, Output: []Float{0.8}}},
+		EventCallback: func(e Event) { events = append(events, e) },
+	}
+	// 少于1000个样本不能panic
+	if err := o.Train(); err != nil {
+		t.Fatal(err)
+	}
+
+	types := []EventType{}
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	epoch := []EventType{EventEpochStart, EventStep, EventStep, EventEvaluate, EventEpochEnd}
+	want := append(append(append(append([]EventType{}, epoch...), epoch...), epoch...), EventTrainEnd)
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Fatal("events:", types)
+	}
+	last := events[len(events)-1]
+	if last.Study != 9 || last.Total != 9 || last.Step != 6 || last.Epoch != 3 || last.Throughput <= 0 || last.Loss <= 0 {
+		t.Fatal("last event:", last)
+	}
+
+	// 达到MinDiff提前结束
+	events = nil
+	o.MinDiff, o.Count = 1, 100
+	o.Train()
+	if len(events) != 7 || events[5].Type != EventEarlyStop || events[5].Reason != "MinDiff" {
+		t.Fatal("early stop:", events)
+	}
+
+	// 输出进度
+	buf := &bytes.Buffer{}
+	o.EventCallback = ConsoleReporter(buf)
+	o.Train()
+	if !strings.Contains(buf.String(), "训练：") || !strings.Contains(buf.String(), "学习次数: 3") {
+		t.Fatal("console:", buf.String())
+	}
+}
+
+// go test nn -run Test_提前结束 -v -count=1
+func Test_提前结束(t *testing.T) {
+	// 训练集与验证集的期望相反，验证集误差从第1轮开始变大
+	o := &NN{
+		Name: "提前结束", Learn: 0.6, MinDiff: 1e-9, Count: 100,
+		InputNum: 1, OutputNum: 1,
+		Layer:      []int{2},
+		RandSeed:   1,
+		Data:       []StData{{Input: []Float{0}, Output: []Float{0.9}}},
+		Validation: []StData{{Input: []Float{0}, Output: []Float{0.1}}},
+		EarlyStop:  &EarlyStopping{Patience: 3},
+	}
+	var best float64
+	o.EventCallback = func(e Event) {
+		if e.Type == EventEpochEnd && e.Epoch == 1 {
+			best = e.ValLoss
+		}
+	}
+	stats, err := o.TrainContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Epoch != 4 || stats.BestEpoch != 1 || stats.ValLoss <= best {
+		t.Fatal("stats:", stats)
+	}
+	// 恢复到第1轮的权重
+	if loss, _ := o.Evaluate(o.Validation); loss != best {
+		t.Fatal("restore:", loss, best)
+	}
+
+	o.EarlyStop.Monitor = "f1"
+	if err := o.Train(); err == nil {
+		t.Fatal("monitor not checked")
+	}
+	o.EarlyStop, o.Validation = &EarlyStopping{}, nil
+	if err := o.Train(); err == nil {
+		t.Fatal("validation not checked")
+	}
+}
+
+// go test nn -run Test_学习率 -v -count=1
+func Test_学习率(t *testing.T) {
+	rate := func(s Schedule, epoch, step int) float64 {
+		return s.Rate(1, Progress{Epoch: epoch, Epochs: 10, Step: step, Steps: 100})
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	if !near(rate(StepDecay{StepSize: 3, Gamma: 0.5}, 5, 0), 0.5) || !near(rate(StepDecay{StepSize: 3, Gamma: 0.5}, 6, 0), 0.25) {
+		t.Fatal("StepDecay")
+	}
+	if !near(rate(ExponentialDecay{Gamma: 0.5}, 2, 0), 0.25) {
+		t.Fatal("ExponentialDecay")
+	}
+	cos := CosineAnnealing{T0: 2, TMult: 2}
+	if !near(rate(cos, 0, 0), 1) || !near(rate(cos, 1, 0), 0.5) || !near(rate(cos, 2, 0), 1) || !near(rate(cos, 4, 0), 0.5) || !near(rate(cos, 6, 0), 1) {
+		t.Fatal("CosineAnnealing")
+	}
+	warm := LinearWarmup{Steps: 4, After: ExponentialDecay{Gamma: 0.5}}
+	if !near(rate(warm, 0, 0), 0.25) || !near(rate(warm, 0, 3), 1) || !near(rate(warm, 1, 4), 0.5) {
+		t.Fatal("LinearWarmup")
+	}
+	one := OneCycle{}
+	if !near(rate(one, 0, 0), 1.0/25) || !near(rate(one, 3, 30), 1) || !near(rate(one, 9, 100), 1.0/25/1e4) {
+		t.Fatal("OneCycle:", rate(one, 0, 0), rate(one, 3, 30), rate(one, 9, 100))
+	}
+
+	plateau := &ReduceOnPlateau{Factor: 0.5, Patience: 2}
+	for _, metric := range []float64{1, 0.9, 0.95, 0.95, 0.8, 0.85} {
+		plateau.Observe(metric)
+	}
+	if !near(rate(plateau, 0, 0), 0.5) || plateau.Best != 0.8 || plateau.Wait != 1 {
+		t.Fatal("ReduceOnPlateau:", plateau)
+	}
+	warm = LinearWarmup{Steps: 1, After: &ReduceOnPlateau{Factor: 0.5, Patience: 1}}
+	for _, metric := range []float64{1, 1} {
+		warm.Observe(metric)
+	}
+	if p := warm.After.(*ReduceOnPlateau); !near(rate(warm, 0, 1), 0.5) || !p.Seen || p.Scale != 0.5 {
+		t.Fatal("LinearWarmup Observe:", p)
+	}
+
+	// 训练时按轮数和修正次数调整学习率
+	var learns []float64
+	o := &NN{
+		Name: "学习率", Learn: 0.8, MinDiff: 1e-9, Count: 3,
+		InputNum: 1, OutputNum: 1,
+		Layer:     []int{2},
+		BatchSize: 2,
+		Schedule:  StepDecay{StepSize: 1, Gamma: 0.5},
+		Data:      []StData{{Input: []Float{0}, Output: []Float{0.8}}, {Input: []Float{1}, Output: []Float{0.2}}, {Input: []Float{0.5}, Output: []Float{0.5}}},
+		EventCallback: func(e Event) {
+			if e.Type == EventStep {
+				learns = append(learns, e.Learn)
+			}
+		},
+	}
+	if err := o.Train(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(learns) != "[0.8 0.8 0.4 0.4 0.2 0.2]" {
+		t.Fatal("train learns:", learns)
+	}
+}
+
+// go test nn -run Test_初始化 -v -count=1
+func Test_初始化(t *testing.T) {
+	rnd := mrand.New(&randSource{state: 1})
+	newW := func(in, out int) [][]Float {
+		w := make([][]Float, in)
+		for k := range w {
+			w[k] = make([]Float, out)
+		}
+		return w
+	}
+	std := func(w [][]Float) float64 {
+		sum, n := 0.0, 0.0
+		for _, row := range w {
+			for _, v := range row {
+				sum += float64(v * v)
+				n++
+			}
+		}
+		return math.Sqrt(sum / n)
+	}
+
+	// 方差与fanIn/fanOut的关系
+	for _, v := range []struct {
+		init Initializer
+		std  float64
+	}{
+		{XavierUniform{}, math.Sqrt(2.0 / (400 + 100))},
+		{XavierNormal{}, math.Sqrt(2.0 / (400 + 100))},
+		{HeUniform{}, math.Sqrt(2.0 / 400)},
+		{HeNormal{}, math.Sqrt(2.0 / 400)},
+		{LeCunUniform{}, math.Sqrt(1.0 / 400)},
+		{LeCunNormal{}, math.Sqrt(1.0 / 400)},
+		{Zeros{}, 0},
+	} {
+		w := newW(400, 100)
+		v.init.Init(w, rnd)
+		if s := std(w); math.Abs(s-v.std) > v.std*0.05 {
+			t.Fatalf("%T: std %v want %v", v.init, s, v.std)
+		}
+	}
+
+	// 正交
+	for _, shape := range [][2]int{{6, 3}, {3, 6}, {4, 4}} {
+		w := newW(shape[0], shape[1])
+		Orthogonal{Gain: 2}.Init(w, rnd)
+		// 较短一边的向量两两正交，长度为Gain
+		for a := 0; a < shape[0] || a < shape[1]; a++ {
+			for b := a; b < shape[0] || b < shape[1]; b++ {
+				dot := 0.0
+				if shape[0] >= shape[1] {
+					if b >= shape[1] {
+						continue
+					}
+					for i := 0; i < shape[0]; i++ {
+						dot += float64(w[i][a] * w[i][b])
+					}
+				} else {
+					if b >= shape[0] {
+						continue
+					}
+					for j := 0; j < shape[1]; j++ {
+						dot += float64(w[a][j] * w[b][j])
+					}
+				}
+				want := 0.0
+				if a == b {
+					want = 4
+				}
+				if math.Abs(dot-want) > tolerance(1e-9) {
+					t.Fatal("orthogonal:", shape, a, b, dot)
+				}
+			}
+		}
+	}
+
+	// 每层单独设置，使用NN自己的随机数
+	o := &NN{
+		InputNum: 3, OutputNum: 1, Layer: []int{2},
+		RandSeed: 5,
+		Initializers: []Initializer{HeNormal{}, InitFunc(func(w [][]Float, rnd *mrand.Rand) {
+			fill(w, func() float64 { return 0.5 })
+		})},
+	}
+	o.Init()
+	if o.Weight[1][0][0] != 0.5 || o.Weight[1][1][0] != 0.5 {
+		t.Fatal("InitFunc:", o.Weight)
+	}
+	n := &NN{InputNum: 3, OutputNum: 1, Layer: []int{2}, RandSeed: 5, Initializers: o.Initializers}
+	n.Init()
+	if n.ToJSON() != o.ToJSON() {
+		t.Fatal("seed:", n.ToJSON(), o.ToJSON())
+	}
+}
+
+// go test nn -run Test_并发预测 -v -count=1 -race
+func Test_并发预测(t *testing.T) {
+	o := &NN{
+		InputNum: 3, OutputNum: 2, Layer: []int{4, 3},
+		RandSeed: 3, Softmax: true,
+		Activations: []Activation{ReLU{}, Tanh{}},
+	}
+	o.Init()
+	inputs := [][]Float{{0.1, 0.2, 0.3}, {-1, 0, 1}, {2, 2, -2}}
+	wants := [][]Float{}
+	for _, v := range inputs {
+		wants = append(wants, append([]Float{}, o.Right(v)...))
+	}
+	output, hidden := o.Output, o.Hidden[0]
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ws := o.NewWorkspace()
+			for n := 0; n < 100; n++ {
+				k := (i + n) % len(inputs)
+				a, err := o.Predict(inputs[k])
+				if err != nil {
+					errs <- err
+					return
+				}
+				b, _ := ws.Predict(inputs[k])
+				if fmt.Sprint(a) != fmt.Sprint(wants[k]) || fmt.Sprint(b) != fmt.Sprint(wants[k]) {
+					errs <- fmt.Errorf("predict %v: %v %v want %v", inputs[k], a, b, wants[k])
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	// 不修改NN
+	if &o.Output[0] != &output[0] || &o.Hidden[0][0] != &hidden[0] {
+		t.Fatal("Predict changed the network")
+	}
+
+	if _, err := o.Predict([]Float{1}); err == nil {
+		t.Fatal("input width not checked")
+	}
+	ws := o.NewWorkspace()
+	if n := testing.AllocsPerRun(100, func() { ws.Predict(inputs[0]) }); n != 0 {
+		t.Fatal("workspace allocs:", n)
+	}
+}
+
+// go test nn -run Test_并行训练 -v -count=1 -race
+func Test_并行训练(t *testing.T) {
+	newNN := func(workers int) *NN {
+		o := &NN{
+			Name: "并行训练", Learn: 0.5, MinDiff: 1e-9, Count: 20,
+			InputNum: 2, OutputNum: 1,
+			Layer:     []int{5},
+			RandSeed:  9,
+			Shuffle:   true,
+			BatchSize: 7,
+			Workers:   workers,
+			Optimizer: &Adam{},
+		}
+		rnd := mrand.New(&randSource{state: 2})
+		for i := 0; i < 50; i++ {
+			x, y := Float(rnd.Float64()), Float(rnd.Float64())
+			o.Data = append(o.Data, StData{Input: []Float{x, y}, Output: []Float{x * y}})
+		}
+		return o
+	}
+	train := func(workers int) (*NN, TrainStats) {
+		o := newNN(workers)
+		stats, err := o.TrainContext(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o, stats
+	}
+
+	serial, s1 := train(1)
+	a, s2 := train(4)
+	b, _ := train(4)
+	// 同样的种子和goroutine数，结果完全相同
+	if a.ToJSON() != b.ToJSON() {
+		t.Fatal("parallel training is not deterministic")
+	}
+	// 与不并行的结果只有浮点误差
+	if s1.Steps != s2.Steps || s1.Study != s2.Study || math.Abs(s1.Loss-s2.Loss) > tolerance(1e-9) {
+		t.Fatal("stats:", s1, s2)
+	}
+	for k1 := range serial.Weight {
+		for k2 := range serial.Weight[k1] {
+			for k3 := range serial.Weight[k1][k2] {
+				if math.Abs(float64(serial.Weight[k1][k2][k3]-a.Weight[k1][k2][k3])) > tolerance(1e-9) {
+					t.Fatal("weight:", serial.Weight, a.Weight)
+				}
+			}
+		}
+	}
+}
+
+// 异步训练不加锁，-race时跳过
+var raceEnabled = false
+
+// go test nn -run Test_异步训练 -v -count=1
+func Test_异步训练(t *testing.T) {
+	if raceEnabled {
+		t.Skip("Hogwild updates race by design")
+	}
+	o := &NN{
+		Name: "异步训练", Learn: 0.5, MinDiff: 1e-9, Count: 300,
+		InputNum: 2, OutputNum: 1,
+		Layer:       []int{5},
+		Activations: []Activation{Tanh{}, Identity{}},
+		Shuffle:     true,
+		Workers:     4,
+		Async:       true,
+	}
+	rnd := mrand.New(&randSource{state: 3})
+	for i := 0; i < 103; i++ {
+		x, y := Float(rnd.Float64()), Float(rnd.Float64())
+		o.Data = append(o.Data, StData{Input: []Float{x, y}, Output: []Float{x - y}})
+	}
+	var epochs int
+	o.EventCallback = func(e Event) {
+		if e.Type == EventEpochEnd {
+			epochs++
+		}
+	}
+	stats, err := o.TrainContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Epoch != 300 || epochs != 300 || stats.Study != 300*103 || stats.Steps != 300*103 || len(stats.Workers) != 4 {
+		t.Fatal("stats:", stats.Epoch, epochs, stats.Study, stats.Steps, len(stats.Workers))
+	}
+	study := 0
+	for _, v := range stats.Workers {
+		study += v.Study
+		if v.Epoch != 300 || v.Study < 300*25 {
+			t.Fatal("worker stats:", v)
+		}
+	}
+	if study != stats.Study {
+		t.Fatal("worker study:", study, stats.Study)
+	}
+	if loss, _ := o.Evaluate(o.Data); loss > 0.05 {
+		t.Fatal("loss:", loss)
+	}
+
+	// 取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := o.TrainContext(ctx); err != context.Canceled {
+		t.Fatal("cancel:", err)
+	}
+
+	o.Optimizer = &Adam{}
+	if err := o.Train(); err == nil {
+		t.Fatal("optimizer not checked")
+	}
+}
+
+// go test nn -run Test_连续存储 -v -count=1
+func Test_连续存储(t *testing.T) {
+	// 每层的权重连续存储
+	o := &NN{InputNum: 2, OutputNum: 1, Layer: []int{3}, RandSeed: 1}
+	o.Init()
+	for k := range o.Weight {
+		if !o.packed(k) {
+			t.Fatal("weight not contiguous")
+		}
+	}
+
+	// JSON仍然是嵌套的数组
+	str := o.ToJSON()
+	if !strings.HasPrefix(str, `{"Weight":[[[`) || !strings.HasSuffix(str, `"Precision":"`+Precision+`"}`) {
+		t.Fatal("json:", str)
+	}
+	n := &NN{InputNum: 2, OutputNum: 1, Layer: []int{3}}
+	if err := n.FromJSON(str); err != nil {
+		t.Fatal(err)
+	}
+	if !n.packed(0) || n.ToJSON() != str {
+		t.Fatal("json round trip:", n.ToJSON())
+	}
+
+	// 直接替换的权重在Right时重新连续存储
+	n.Weight[1] = [][]Float{{1}, {2}, {3}}
+	n.Bias[1] = []Float{0}
+	want0 := append([]Float{}, n.Right([]Float{0.5, 0.5})...)
+	if !n.packed(1) || n.Weight[1][2][0] != 3 {
+		t.Fatal("weight not packed:", n.Weight[1])
+	}
+	h := n.Hidden[0]
+	if out := n.Right([]Float{0.5, 0.5}); out[0] != want0[0] || out[0] != Float((Sigmoid{}).Forward(float64(h[0]*1+h[1]*2+h[2]*3))) {
+		t.Fatal("right:", out, want0)
+	}
+	input := []Float{0.5, 0.5}
+	if n := testing.AllocsPerRun(100, func() { o.Right(input) }); n != 0 {
+		t.Fatal("right allocs:", n)
+	}
+}
+
+// go test nn -run Test_模型文件 -v -count=1
+func Test_模型文件(t *testing.T) {
+	o := &NN{
+		Name: "模型", Learn: 0.05, MinDiff: 1e-6, Count: 20, RandSeed: 3,
+		InputNum: 2, OutputNum: 1,
+		Layer:        []int{3, 2},
+		Activations:  []Activation{LeakyReLU{Alpha: 0.2}, nil, Identity{}},
+		Initializers: []Initializer{Orthogonal{Gain: 2}, nil, XavierNormal{}},
+		Loss:         Huber{Delta: 0.5},
+		Optimizer:    &Adam{Beta1: 0.8},
+		Schedule:     LinearWarmup{Steps: 5, After: &ReduceOnPlateau{Factor: 0.5}},
+		EarlyStop:    &EarlyStopping{Monitor: "accuracy", Patience: 3},
+		Meta:         map[string]string{"dataset": "加法"},
+	}
+	for i := 0; i < 10; i++ {
+		x := Float(i)
+		o.Data = append(o.Data, StData{Input: []Float{x, 10 - x}, Output: []Float{x * 2}})
+	}
+	o.Validation = o.Data
+	if err := o.normalizing(&o.Data); err != nil {
+		t.Fatal(err)
+	}
+	if n := o.Normalization; n.InputMin != 0 || n.InputMax != 10 || n.OutputMin != 0 || n.OutputMax != 18 {
+		t.Fatal("normalization:", n)
+	}
+	if err := o.Train(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := o.WriteModel(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"Type":"nn.LeakyReLU","Params":{"Alpha":0.2}`) {
+		t.Fatal("model:", buf.String())
+	}
+	n, err := ReadModel(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Name != o.Name || n.Learn != o.Learn || n.Count != o.Count || n.RandSeed != o.RandSeed || fmt.Sprint(n.Layer) != "[3 2]" ||
+		n.Meta["dataset"] != "加法" || *n.Normalization != *o.Normalization || n.EarlyStop.Monitor != "accuracy" || n.EarlyStop.Patience != 3 {
+		t.Fatal("fields:", n)
+	}
+	if fmt.Sprint(n.Activations, n.Initializers, n.Loss) != fmt.Sprint(o.Activations, o.Initializers, o.Loss) {
+		t.Fatal("components:", n.Activations, n.Initializers, n.Loss)
+	}
+	if s := n.Schedule.(LinearWarmup); s.Steps != 5 || s.After.(*ReduceOnPlateau).Factor != 0.5 {
+		t.Fatal("schedule:", n.Schedule)
+	}
+	if a := n.Optimizer.(*Adam); a.Beta1 != 0.8 || a.T != o.Optimizer.(*Adam).T || fmt.Sprint(a.M) != fmt.Sprint(o.Optimizer.(*Adam).M) {
+		t.Fatal("optimizer:", a)
+	}
+	for _, d := range o.Data {
+		if a, b := o.Right(d.Input)[0], n.Right(d.Input)[0]; a != b {
+			t.Fatal("right:", a, b)
+		}
+	}
+
+	// Predict使用读取的Normalization，不修改输入
+	raw := []Float{3, 7}
+	x := append([]Float(nil), raw...)
+	o.Normalization.Input(x)
+	want := o.Right(x)
+	o.Normalization.Output(want)
+	if got, err := n.Predict(raw); err != nil || got[0] != want[0] || raw[0] != 3 || raw[1] != 7 {
+		t.Fatal("predict:", got, want, raw, err)
+	}
+
+	// 文件
+	fileName := filepath.Join(os.TempDir(), "nn_model_test.json")
+	defer os.Remove(fileName)
+	if err := o.SaveModel(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := LoadModel(fileName); err != nil || n.ToJSON() != o.ToJSON() {
+		t.Fatal("load:", err)
+	}
+
+	// 权重文件不是模型文件
+	if err := o.SaveWeight(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadModel(fileName); err == nil {
+		t.Fatal("weight file loaded as model")
+	}
+	// 结构与权重不同
+	bad := strings.Replace(buf.String(), `"Layer":[3,2]`, `"Layer":[3,3]`, 1)
+	if _, err := ReadModel(strings.NewReader(bad)); err == nil {
+		t.Fatal("shape not checked")
+	}
+	// 没有注册的类型
+	o.Activations[1] = testActivation{}
+	if _, err := o.Model(); err == nil {
+		t.Fatal("unregistered type saved")
+	}
+	Register(testActivation{})
+	if _, err := o.Model(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不能保存的初始化方法保存为nil，不影响重建权重
+	o.Initializers[0] = InitFunc(func(w [][]Float, rnd *mrand.Rand) {})
+	buf.Reset()
+	if err := o.WriteModelBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ReadModel(buf); err != nil || n.Initializers[0] != nil || n.Initializers[2] == nil || n.ToJSON() != o.ToJSON() {
+		t.Fatal("init func:", err)
+	}
+}
+
+type testActivation struct{ Identity }
+
+// go test nn -run Test_二进制模型 -v -count=1
+func Test_二进制模型(t *testing.T) {
+	o, input, output := newBenchNN()
+	o.Activations = []Activation{ReLU{}, Tanh{}, nil}
+	o.Meta = map[string]string{"a": "b"}
+	js, bin := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := o.WriteModel(js); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.WriteModelBinary(bin); err != nil {
+		t.Fatal(err)
+	}
+	if bin.Len()*2 > js.Len() {
+		t.Fatal("binary size:", bin.Len(), js.Len())
+	}
+	data := append([]byte{}, bin.Bytes()...)
+	n, err := ReadModel(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ToJSON() != o.ToJSON() || n.Meta["a"] != "b" || fmt.Sprint(n.Activations) != fmt.Sprint(o.Activations) {
+		t.Fatal("binary round trip")
+	}
+	if a, b := fmt.Sprint(o.Right(input)), fmt.Sprint(n.Right(input)); a != b {
+		t.Fatal("right:", a, b)
+	}
+
+	// 截断和损坏的文件
+	for _, size := range []int{0, 3, 10, 100, len(data) / 2, len(data) - 4, len(data) - 1} {
+		if _, err := ReadModelBinary(bytes.NewReader(data[:size])); err == nil {
+			t.Fatal("truncated file loaded:", size)
+		}
+	}
+	bad := append([]byte{}, data...)
+	bad[len(bad)/2] ^= 1
+	if _, err := ReadModelBinary(bytes.NewReader(bad)); err != ErrChecksum {
+		t.Fatal("checksum:", err)
+	}
+	bad = append([]byte("NNMX"), data[4:]...)
+	if _, err := ReadModelBinary(bytes.NewReader(bad)); err == nil {
+		t.Fatal("magic not checked")
+	}
+
+	// 32位的文件转换为当前精度
+	bin.Reset()
+	if err := o.writeBinary(bin, 32); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = ReadModel(bin); err != nil {
+		t.Fatal(err)
+	}
+	if w := o.Weight[1][2][3]; n.Weight[1][2][3] != Float(float32(w)) || Precision == "float64" && n.Weight[1][2][3] == w {
+		t.Fatal("float32:", n.Weight[1][2][3], w)
+	}
+
+	// 按文件名选择格式，读取时自动判断
+	for _, name := range []string{"nn_model_test.bin", "nn_model_test.json"} {
+		fileName := filepath.Join(os.TempDir(), name)
+		defer os.Remove(fileName)
+		if err := o.SaveModel(fileName); err != nil {
+			t.Fatal(err)
+		}
+		bs, _ := ioutil.ReadFile(fileName)
+		if strings.HasPrefix(string(bs), binaryMagic) != strings.HasSuffix(name, ".bin") {
+			t.Fatal("format:", name)
+		}
+		if n, err := LoadModel(fileName); err != nil || n.ToJSON() != o.ToJSON() {
+			t.Fatal("load:", name, err)
+		}
+	}
+
+	// 读取版本1的文件
+	bin.Reset()
+	if err := o.WriteModelBinary(bin); err != nil {
+		t.Fatal(err)
+	}
+	data = bin.Bytes()
+	binary.LittleEndian.PutUint16(data[4:], 1)
+	binary.LittleEndian.PutUint32(data[len(data)-4:], crc32.ChecksumIEEE(data[:len(data)-4]))
+	if n, err := ReadModel(bytes.NewReader(data)); err != nil || n.ToJSON() != o.ToJSON() {
+		t.Fatal("version 1:", err)
+	}
+
+	// 头太大时不写入
+	o.Meta = map[string]string{"a": strings.Repeat("a", maxBinaryHeader)}
+	if err := o.WriteModelBinary(ioutil.Discard); err == nil {
+		t.Fatal("header size not checked")
+	}
+
+	// 优化器的状态不在头中，大的网络也能读取
+	o = &NN{InputNum: 784, OutputNum: 10, Layer: []int{1000, 1000}, RandSeed: 1, Learn: 0.01, Optimizer: &Adam{}}
+	o.Init()
+	o.Right(input)
+	o.Left(input, output)
+	bin.Reset()
+	if err := o.WriteModelBinary(bin); err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.LittleEndian.Uint32(bin.Bytes()[7:]); size > 1<<20 {
+		t.Fatal("header size:", size)
+	}
+	if n, err = ReadModel(bin); err != nil {
+		t.Fatal(err)
+	}
+	a, b := o.Optimizer.(*Adam), n.Optimizer.(*Adam)
+	if a.T != b.T || !reflect.DeepEqual(a.M, b.M) || !reflect.DeepEqual(a.V, b.V) {
+		t.Fatal("adam state:", b.T)
+	}
+	if a, b := fmt.Sprint(o.Right(input)), fmt.Sprint(n.Right(input)); a != b {
+		t.Fatal("adam right:", a, b)
+	}
+}
+
+// go test nn -run Test_检查点 -v -count=1
+func Test_检查点(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nn_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newNN := func(batch int) *NN {
+		o := &NN{
+			Learn: 0.05, MinDiff: 1e-9, Count: 12, RandSeed: 5, Shuffle: true, BatchSize: batch,
+			InputNum: 2, OutputNum: 1,
+			Layer:       []int{4},
+			Activations: []Activation{Tanh{}, Identity{}},
+			Optimizer:   &Adam{},
+			Schedule:    LinearWarmup{Steps: 10, After: &ReduceOnPlateau{Patience: 2}},
+			EarlyStop:   &EarlyStopping{Patience: 100},
+		}
+		for i := 0; i < 10; i++ {
+			x := Float(i) / 10
+			o.Data = append(o.Data, StData{Input: []Float{x, 1 - x}, Output: []Float{x * x}})
+		}
+		o.Validation = o.Data[:5]
+		return o
+	}
+
+	for _, batch := range []int{0, 3} {
+		want := newNN(batch)
+		wantStats, err := want.TrainContext(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 取消或收到信号时在一轮的中间停止，保存最后的检查点
+		for _, signal := range []bool{false, true} {
+			if signal && runtime.GOOS == "windows" {
+				continue
+			}
+			o := newNN(batch)
+			o.Checkpoint = &Checkpointing{Dir: dir, Prefix: fmt.Sprint("batch", batch, signal), Every: 2, Keep: 2, IgnoreSignal: !signal}
+			ctx, cancel := context.WithCancel(context.Background())
+			o.EventCallback = func(e Event) {
+				if e.Type == EventStep && e.Step == 25 {
+					if signal {
+						p, _ := os.FindProcess(os.Getpid())
+						p.Signal(os.Interrupt)
+						time.Sleep(10 * time.Millisecond)
+					} else {
+						cancel()
+					}
+				}
+			}
+			_, err := o.TrainContext(ctx)
+			cancel()
+			if signal && !errors.Is(err, ErrInterrupted) || !signal && err != context.Canceled {
+				t.Fatal("stop:", err)
+			}
+			files, _ := o.Checkpoint.files()
+			if len(files) != 2 {
+				t.Fatal("keep:", files)
+			}
+			latest, _ := o.Checkpoint.Latest()
+
+			// 从检查点继续，结果与不中断时相同
+			n := newNN(batch)
+			stats, err := n.ResumeContext(context.Background(), latest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.ToJSON() != want.ToJSON() || stats.Study != wantStats.Study || stats.Steps != wantStats.Steps || stats.Epoch != wantStats.Epoch ||
+				stats.Loss != wantStats.Loss || stats.BestEpoch != wantStats.BestEpoch {
+				t.Fatalf("resume batch %d signal %v: %+v %+v", batch, signal, stats, wantStats)
+			}
+			// 学习率调整的状态
+			p, wantP := n.Schedule.(LinearWarmup).After.(*ReduceOnPlateau), want.Schedule.(LinearWarmup).After.(*ReduceOnPlateau)
+			if !wantP.Seen || wantP.Scale >= 1 || p.Scale != wantP.Scale || p.Best != wantP.Best || p.Wait != wantP.Wait || p.Seen != wantP.Seen {
+				t.Fatalf("schedule batch %d signal %v: %+v %+v", batch, signal, p, wantP)
+			}
+		}
+	}
+
+	// 结构不同的网络
+	o := newNN(0)
+	o.Checkpoint = &Checkpointing{Dir: dir, Every: 1, IgnoreSignal: true}
+	o.Count = 2
+	if err := o.Train(); err != nil {
+		t.Fatal(err)
+	}
+	latest, _ := o.Checkpoint.Latest()
+	if latest == "" {
+		t.Fatal("no checkpoint")
+	}
+	n := newNN(0)
+	n.Layer = []int{3}
+	if err := n.Resume(latest); err == nil {
+		t.Fatal("layers not checked")
+	}
+	if err := n.Resume(filepath.Join(dir, "none.bin")); err == nil {
+		t.Fatal("missing file")
+	}
+
+	// 按保存的序号排序，回滚后修正次数变小的检查点也是最新的；前缀更长的检查点不属于这次训练
+	other := filepath.Join(dir, "run-b-0000000009.bin")
+	if err := ioutil.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &Checkpointing{Dir: dir, Prefix: "run", Keep: 2}
+	var names []string
+	for _, steps := range []int{10, 5, 3} {
+		name, err := o.saveCheckpoint(config, &TrainState{Stats: TrainStats{Steps: steps}, Epoch: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	files, _ := config.files()
+	if latest, _ := config.Latest(); fmt.Sprint(files) != fmt.Sprint(names[1:]) || latest != names[2] {
+		t.Fatal("sequence:", files, latest)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatal("other prefix:", err)
+	}
+}
+
+// go test nn -run Test_形状检查 -v -count=1
+func Test_形状检查(t *testing.T) {
+	// 网络结构
+	for _, o := range []*NN{
+		{InputNum: 0, OutputNum: 1},
+		{InputNum: 2, OutputNum: 1, Layer: []int{3, 0}},
+		{InputNum: 2, OutputNum: 1, Layer: []int{3}, Activations: []Activation{Tanh{}, Tanh{}, Tanh{}}},
+		{InputNum: 2, OutputNum: 1, Layer: []int{3}, Weight: [][][]Float{{{1, 2, 3}, {4, 5, 6}}}},
+	} {
+		if err := o.Validate(); err == nil {
+			t.Fatalf("validate: %+v", o)
+		}
+		if err := o.Init(); err == nil {
+			t.Fatalf("init: %+v", o)
+		}
+	}
+
+	o := &NN{InputNum: 2, OutputNum: 1, Layer: []int{3}, Weight: [][][]Float{{{1, 2, 3}, {4, 5, 6}}, {{1}, {2}}}}
+	err := o.Validate()
+	shape := &ShapeError{}
+	if !errors.Is(err, ErrShapeMismatch) || !errors.As(err, &shape) || *shape != (ShapeError{Layer: 1, What: "weight rows", Want: 3, Got: 2}) {
+		t.Fatal("weight:", err)
+	}
+	if err.Error() != "shape mismatch: layer 1 weight rows: got 2, want 3" {
+		t.Fatal(err)
+	}
+
+	// 重复Init不增加隐藏层
+	o = &NN{InputNum: 2, OutputNum: 1, Layer: []int{3, 2}}
+	for i := 0; i < 3; i++ {
+		if err := o.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(o.Hidden) != len(o.Layer) {
+		t.Fatal("hidden:", len(o.Hidden))
+	}
+
+	// 没有Init或没有偏置时先初始化
+	for _, n := range []*NN{
+		{InputNum: 2, OutputNum: 1, Layer: []int{2}},
+		{InputNum: 2, OutputNum: 1, Layer: []int{2}, Weight: [][][]Float{{{1, 2}, {3, 4}}, {{1}, {2}}}},
+	} {
+		if output, err := n.Forward([]Float{1, 0}); err != nil || len(output) != 1 || len(n.Bias) != 2 {
+			t.Fatal("forward before init:", output, err)
+		}
+	}
+	b := &NN{InputNum: 2, OutputNum: 1, Layer: []int{2}, Weight: [][][]Float{{{1, 2}, {3, 4}}, {{1}, {2}}}}
+	if err := b.Backward([]Float{1, 0}, []Float{1}); err != nil || b.Bias == nil {
+		t.Fatal("backward before init:", err)
+	}
+
+	// 输入和输出的宽度
+	if _, err := o.Forward([]Float{1}); !errors.Is(err, ErrShapeMismatch) {
+		t.Fatal("forward:", err)
+	}
+	output, err := o.Forward([]Float{1, 0})
+	if err != nil || len(output) != 1 {
+		t.Fatal("forward:", output, err)
+	}
+	if err := o.Backward([]Float{1, 0}, []Float{1, 0}); !errors.Is(err, ErrShapeMismatch) {
+		t.Fatal("backward:", err)
+	}
+	if err := o.Backward([]Float{1, 0}, []Float{1}); err != nil {
+		t.Fatal("backward:", err)
+	}
+	if _, err := o.NewWorkspace().Predict([]Float{1, 0, 0}); !errors.Is(err, ErrShapeMismatch) {
+		t.Fatal("predict:", err)
+	}
+
+	// 没有初始化、缺少偏置或权重与偏置不一致时Predict返回错误，不修改NN
+	for _, n := range []*NN{
+		{InputNum: 2, OutputNum: 1, Layer: []int{2}},
+		{InputNum: 2, OutputNum: 1, Layer: []int{2}, Weight: [][][]Float{{{1, 2}, {3, 4}}, {{1}, {2}}}},
+		{InputNum: 2, OutputNum: 1, Layer: []int{2}, Weight: [][][]Float{{{1, 2, 3}, {3, 4, 5}}, {{1}, {2}}}, Bias: [][]Float{{0, 0}, {0}}},
+	} {
+		if _, err := n.Predict([]Float{1, 0}); !errors.Is(err, ErrShapeMismatch) || n.Bias != nil && len(n.Bias[0]) != 2 {
+			t.Fatal("predict:", err)
+		}
+		if _, err := n.NewWorkspace().Predict([]Float{1, 0}); !errors.Is(err, ErrShapeMismatch) {
+			t.Fatal("workspace predict:", err)
+		}
+	}
+	ws := o.NewWorkspace()
+	rows := o.Weight[1]
+	o.Weight[1] = rows[:1]
+	if _, err := ws.Predict([]Float{1, 0}); err == nil {
+		t.Fatal("workspace predict after changing the weight")
+	}
+	o.Weight[1] = rows
+	o.Data = []StData{{Input: []Float{1, 0}, Output: []Float{1}}, {Input: []Float{1, 0}, Output: []Float{1, 1}}}
+	if err := o.Train(); !errors.Is(err, ErrShapeMismatch) || !strings.HasPrefix(err.Error(), "sample 1: ") {
+		t.Fatal("train:", err)
+	}
+
+	// 不匹配的权重文件在预测之前返回错误，不修改权重
+	want := o.ToJSON()
+	n := &NN{InputNum: 2, OutputNum: 1, Layer: []int{4}}
+	n.Init()
+	for _, str := range []string{n.ToJSON(), `[[[1,2,3],[4,5,6]],[[1],[2],[3],[4]]]`, `{"Weight":[[[1,2,3],[4,5,6]],[[1],[2],[3]]],"Bias":[[0,0],[0]]}`} {
+		if err := o.FromJSON(str); !errors.Is(err, ErrShapeMismatch) {
+			t.Fatal("from json:", str, err)
+		}
+		if o.ToJSON() != want {
+			t.Fatal("weight changed:", str)
+		}
+	}
+	fresh := &NN{InputNum: 2, OutputNum: 1, Layer: []int{2}}
+	if err := fresh.FromJSON(n.ToJSON()); !errors.Is(err, ErrShapeMismatch) ||
+		fresh.Weight != nil || fresh.Bias != nil || fresh.Hidden != nil || fresh.MinDiff != 0 || fresh.Count != 0 {
+		t.Fatalf("from json changed the network: %v %+v", err, fresh)
+	}
+	fileName := filepath.Join(os.TempDir(), "nn_shape_test.weight")
+	defer os.Remove(fileName)
+	if err := n.SaveWeight(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.LoadWeight(fileName); !errors.Is(err, ErrShapeMismatch) {
+		t.Fatal("load weight:", err)
+	}
+	if _, err := o.Forward([]Float{1, 0}); err != nil || o.ToJSON() != want {
+		t.Fatal("after load:", err)
+	}
+}
+
+// go test nn -run Test_数值检查 -v -count=1
+func Test_数值检查(t *testing.T) {
+	// 线性网络，学习率太大时第一轮就溢出
+	newNN := func(guard *NumericGuard) *NN {
+		o := &NN{
+			Learn: 1e30, MinDiff: 1e-9, Count: 200, RandSeed: 3,
+			InputNum: 1, OutputNum: 1,
+			Layer:        []int{2},
+			Activations:  []Activation{Identity{}, Identity{}},
+			Initializers: []Initializer{XavierUniform{}, XavierUniform{}},
+			Loss:         MSE{},
+			Guard:        guard,
+		}
+		for i := 0; i < 10; i++ {
+			x := Float(i) / 10
+			o.Data = append(o.Data, StData{Input: []Float{x}, Output: []Float{2 * x}})
+		}
+		return o
+	}
+
+	// 停止训练
+	o := newNN(&NumericGuard{})
+	err := o.Train()
+	e := &NumericError{}
+	if !errors.Is(err, ErrNonFinite) || !errors.As(err, &e) || e.Epoch < 1 || e.Step < 1 {
+		t.Fatal("stop:", err)
+	}
+	if !strings.HasPrefix(err.Error(), "non-finite value: epoch ") {
+		t.Fatal(err)
+	}
+
+	// 回滚并降低学习率，逐样本、并行批量和异步训练
+	for _, v := range []struct {
+		batch, workers int
+		async          bool
+	}{{0, 0, false}, {4, 2, false}, {0, 2, true}} {
+		if v.async && raceEnabled {
+			continue // 异步训练不加锁，-race时跳过
+		}
+		rollbacks := 0
+		o := newNN(&NumericGuard{Rollback: true, Decay: 1e-31})
+		o.BatchSize, o.Workers, o.Async = v.batch, v.workers, v.async
+		o.EventCallback = func(e Event) {
+			if e.Type == EventRollback {
+				rollbacks++
+				if !strings.HasPrefix(e.Reason, "non-finite value") {
+					t.Fatal("reason:", e.Reason)
+				}
+			}
+		}
+		stats, err := o.TrainContext(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rollbacks != 1 || stats.Learn != o.Learn*1e-31 || o.checkParams() != nil {
+			t.Fatalf("rollback %+v: %v %+v", v, rollbacks, stats)
+		}
+		if l := o.Loss.Loss(o.Right([]Float{0.5}), []Float{1}); math.IsNaN(l) || l > 0.01 {
+			t.Fatalf("loss %+v: %v", v, l)
+		}
+	}
+
+	// 回滚次数用完后停止
+	o = newNN(&NumericGuard{Rollback: true, MaxRollback: 2, Decay: 0.9})
+	if err := o.Train(); !errors.Is(err, ErrNonFinite) {
+		t.Fatal("max rollback:", err)
+	}
+
+	// 样本中的NaN
+	o = newNN(&NumericGuard{})
+	o.Learn = 0.1
+	o.Data[3].Input[0] = Float(math.NaN())
+	err = o.Train()
+	if !errors.As(err, &e) || e.What != "input" || e.Sample != 3 || e.Layer != -1 {
+		t.Fatal("input:", err)
+	}
+
+	// 梯度裁剪
+	for _, clip := range []struct{ value, norm float64 }{{0.01, 0}, {0, 0.01}} {
+		o := &NN{InputNum: 2, OutputNum: 1, Layer: []int{3}, Learn: 1, ClipValue: clip.value, ClipNorm: clip.norm}
+		o.Init()
+		before := copyWeight(o.Weight, nil)
+		bias := copyBias(o.Bias, nil)
+		o.Right([]Float{1, 1})
+		o.Left([]Float{1, 1}, []Float{100})
+		sum, max := 0.0, 0.0
+		for k := range o.Weight {
+			for i := range o.Weight[k] {
+				for j, v := range o.Weight[k][i] {
+					d := math.Abs(float64(v - before[k][i][j]))
+					sum, max = sum+d*d, math.Max(max, d)
+				}
+			}
+			for j, v := range o.Bias[k] {
+				d := math.Abs(float64(v - bias[k][j]))
+				sum, max = sum+d*d, math.Max(max, d)
+			}
+		}
+		if max == 0 || clip.value > 0 && max > clip.value+tolerance(1e-12) || clip.norm > 0 && math.Sqrt(sum) > clip.norm+tolerance(1e-12) {
+			t.Fatalf("clip %+v: max %v norm %v", clip, max, math.Sqrt(sum))
+		}
+
+		// 保存在模型文件中
+		o.Guard = &NumericGuard{Rollback: true, MaxRollback: 5}
+		buf := &bytes.Buffer{}
+		if err := o.WriteModel(buf); err != nil {
+			t.Fatal(err)
+		}
+		n, err := ReadModel(buf)
+		if err != nil || n.ClipValue != o.ClipValue || n.ClipNorm != o.ClipNorm || !n.Guard.Rollback || n.Guard.MaxRollback != 5 {
+			t.Fatal("model:", n, err)
+		}
+	}
+}
+
+// go test nn -run Test_正则化 -v -count=1
+func Test_正则化(t *testing.T) {
+	weight := func() [][][]Float { return [][][]Float{{{0.5, -2, 0}, {1, 0.25, -1}}, {{3}, {-0.5}, {2}}} }
+	newNN := func() *NN {
+		return &NN{Learn: 0.1, InputNum: 2, OutputNum: 1, Layer: []int{3}, Weight: weight()}
+	}
+
+	// 正则化项，每层可以单独设置
+	o := newNN()
+	o.L1, o.L2 = 0.1, 0.2
+	o.Regularizations = []*Regularization{nil, {L2: 1}}
+	o.Init()
+	want := 0.1*(0.5+2+0+1+0.25+1) + 0.1*(0.25+4+0+1+0.0625+1) + 0.5*(9+0.25+4)
+	if p := o.penalty(); math.Abs(p-want) > 1e-12 {
+		t.Fatal("penalty:", p, want)
+	}
+
+	// 修正时加上正则化项的梯度
+	n := newNN()
+	n.Init()
+	input, output := []Float{0.3, -0.7}, []Float{0.2}
+	o.Right(input)
+	o.Left(input, output)
+	n.Right(input)
+	n.Left(input, output)
+	for k, w := range weight() {
+		r := o.regularization(k)
+		for i, row := range w {
+			for j, v := range row {
+				d := r.L2 * float64(v)
+				if v != 0 {
+					d += r.L1 * math.Copysign(1, float64(v))
+				}
+				if got := float64(o.Weight[k][i][j] - n.Weight[k][i][j]); math.Abs(got+o.Learn*d) > tolerance(1e-12) {
+					t.Fatalf("update %d %d %d: %v %v", k, i, j, got, -o.Learn*d)
+				}
+			}
+		}
+	}
+	if fmt.Sprint(o.Bias) != fmt.Sprint(n.Bias) {
+		t.Fatal("bias regularized:", o.Bias, n.Bias)
+	}
+
+	// 报告的平均误差包括正则化项，学习率为0时权重不变
+	o = newNN()
+	o.Learn, o.Count, o.L2 = 0, 1, 0.5
+	o.Loss = MSE{}
+	o.Data = []StData{{Input: []Float{0.1, 0.2}, Output: []Float{0.3}}, {Input: []Float{0.4, 0.5}, Output: []Float{0.6}}}
+	stats, err := o.TrainContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	loss := 0.0
+	for _, d := range o.Data {
+		loss += o.Loss.Loss(o.Right(d.Input), d.Output) / 2
+	}
+	if val, _ := o.Evaluate(o.Data); math.Abs(stats.Loss-loss-o.penalty()) > 1e-12 || math.Abs(val-stats.Loss) > 1e-12 || o.penalty() == 0 {
+		t.Fatal("loss:", stats.Loss, val, loss, o.penalty())
+	}
+
+	// L2使权重变小，MaxNorm限制每个神经元的输入权重
+	norm := func(o *NN) (sum, max float64) {
+		for _, w := range o.Weight {
+			for j := range w[0] {
+				col := 0.0
+				for _, row := range w {
+					col += float64(row[j]) * float64(row[j])
+				}
+				sum, max = sum+col, math.Max(max, math.Sqrt(col))
+			}
+		}
+		return
+	}
+	var sums []float64
+	for _, v := range []struct{ l2, maxNorm float64 }{{0, 0}, {0.01, 0}, {0, 1}} {
+		o := &NN{
+			Learn: 0.05, MinDiff: 1e-9, Count: 100, RandSeed: 1,
+			InputNum: 2, OutputNum: 1, Layer: []int{4},
+			Activations: []Activation{Tanh{}, Identity{}},
+			L2:          v.l2, MaxNorm: v.maxNorm,
+		}
+		for i := 0; i < 20; i++ {
+			x := Float(i) / 20
+			o.Data = append(o.Data, StData{Input: []Float{x, 1 - x}, Output: []Float{3 * x}})
+		}
+		if err := o.Train(); err != nil {
+			t.Fatal(err)
+		}
+		sum, max := norm(o)
+		if v.maxNorm > 0 && max > v.maxNorm+tolerance(1e-9) {
+			t.Fatal("max norm:", max)
+		}
+		sums = append(sums, sum)
+	}
+	if sums[1] >= sums[0] || sums[2] >= sums[0] {
+		t.Fatal("weights not reduced:", sums)
+	}
+
+	// 参数检查和模型文件
+	o = newNN()
+	o.L2 = -1
+	if err := o.Validate(); err == nil {
+		t.Fatal("negative L2")
+	}
+	o.L2, o.Regularizations = 0, make([]*Regularization, 3)
+	if err := o.Validate(); !errors.Is(err, ErrShapeMismatch) {
+		t.Fatal("regularizations:", err)
+	}
+	o.L1, o.MaxNorm, o.Regularizations = 0.01, 2, []*Regularization{nil, {L2: 0.5}}
+	buf := &bytes.Buffer{}
+	if err := o.WriteModel(buf); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ReadModel(buf); err != nil || n.L1 != 0.01 || n.MaxNorm != 2 || n.Regularizations[0] != nil || *n.Regularizations[1] != (Regularization{L2: 0.5}) {
+		t.Fatal("model:", n, err)
+	}
+}
+
+// 类似Mnist大小的网络
+func newBenchNN() (*NN, []Float, []Float) {
+	o := &NN{InputNum: 784, OutputNum: 10, Layer: []int{128, 64}, RandSeed: 1, Learn: 0.1, Initializers: []Initializer{HeUniform{}, HeUniform{}, XavierUniform{}}}
+	o.Init()
+	input, output := make([]Float, 784), make([]Float, 10)
+	for k := range input {
+		input[k] = Float(k%256) / 255
+	}
+	output[3] = 1
+	return o, input, output
+}
+
+// go test nn -run none -bench Benchmark_Right -benchmem
+func Benchmark_Right(b *testing.B) {
+	o, input, _ := newBenchNN()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o.Right(input)
+	}
+}
+
+// go test nn -run none -bench Benchmark_Left -benchmem
+func Benchmark_Left(b *testing.B) {
+	o, input, output := newBenchNN()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o.Right(input)
+		o.Left(input, output)
+	}
+}
+
+// go test nn -run none -bench Benchmark_批量 -benchmem
+func Benchmark_批量(b *testing.B) {
+	o, input, output := newBenchNN()
+	o.Count, o.BatchSize, o.MinDiff = 1, 32, 1e-9
+	for i := 0; i < 128; i++ {
+		o.Data = append(o.Data, StData{Input: input, Output: output})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o.Train()
+	}
+}
+
+// 比较浮点数时允许的误差，单精度时不小于1e-5
+func tolerance(v float64) float64 {
+	if Precision == "float32" {
+		return math.Max(v, 1e-5)
+	}
+	return v
+}
@@ -0,0 +1,76 @@
+package nn
+
+import "errors"
+
+// Normalization 样本的线性归一化，输入和输出分别把[Min,Max]映射到[0,1]
+type Normalization struct {
+	InputMin, InputMax   Float
+	OutputMin, OutputMax Float
+}
+
+// NewNormalization 查找data中输入和输出的最大最小值，已经在[0,1]内的不缩放
+func NewNormalization(data []StData) (*Normalization, error) {
+	if len(data) == 0 {
+		return nil, errors.New("no data")
+	}
+	o := &Normalization{}
+	var err error
+	if o.InputMin, o.InputMax, err = bounds(data, func(v StData) []Float { return v.Input }); err != nil {
+		return nil, err
+	}
+	if o.OutputMin, o.OutputMax, err = bounds(data, func(v StData) []Float { return v.Output }); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// 所有样本的最大最小值
+func bounds(data []StData, values func(v StData) []Float) (min, max Float, err error) {
+	first := true
+	for _, v := range data {
+		for _, vv := range values(v) {
+			if first || vv < min {
+				min = vv
+			}
+			if first || vv > max {
+				max = vv
+			}
+			first = false
+		}
+	}
+	if max == min {
+		return 0, 0, errors.New("min == max")
+	}
+	if min >= 0 && max <= 1 {
+		return 0, 1, nil
+	}
+	return min, max, nil
+}
+
+// Normalize 归一化样本的输入和输出，直接修改data
+func (o *Normalization) Normalize(data []StData) {
+	for _, v := range data {
+		normalize(v.Input, o.InputMin, o.InputMax)
+		normalize(v.Output, o.OutputMin, o.OutputMax)
+	}
+}
+
+// Input 归一化Right之前的输入，直接修改x
+func (o *Normalization) Input(x []Float) {
+	normalize(x, o.InputMin, o.InputMax)
+}
+
+// Output 把Right的输出还原到原来的范围，直接修改y
+func (o *Normalization) Output(y []Float) {
+	for k, v := range y {
+		y[k] = o.OutputMin + v*(o.OutputMax-o.OutputMin)
+	}
+}
+
+// Y=k*(X-Min)，k=1/(Max-Min)
+func normalize(x []Float, min, max Float) {
+	k := 1 / (max - min)
+	for i, v := range x {
+		x[i] = k * (v - min)
+	}
+}
@@ -0,0 +1,185 @@
+package nn
+
+import (
+	"math"
+
+	"nn/tensor"
+)
+
+// Optimizer 优化器，根据梯度修正参数，自己保存每个参数的状态
+type Optimizer interface {
+	// params和grads一一对应，learn为学习率
+	Update(params, grads [][]Float, learn float64)
+}
+
+// SGD 随机梯度下降 w -= learn*g
+type SGD struct{}
+
+// Update ...
+func (SGD) Update(params, grads [][]Float, learn float64) {
+	for k, p := range params {
+		tensor.Axpy(Float(-learn), grads[k], p)
+	}
+}
+
+// Momentum 动量，Momentum默认0.9，Nesterov为true时使用Nesterov动量
+type Momentum struct {
+	Momentum float64
+	Nesterov bool
+	Velocity [][]Float // 速度
+}
+
+// Update ...
+func (o *Momentum) Update(params, grads [][]Float, learn float64) {
+	mu := o.Momentum
+	if mu <= 0 {
+		mu = 0.9
+	}
+	if !sameShape(o.Velocity, params) {
+		o.Velocity = zerosLike(params)
+	}
+	for k, p := range params {
+		g, v := grads[k], o.Velocity[k]
+		if !o.Nesterov {
+			// v = mu*v - learn*g, p += v
+			tensor.Scal(Float(mu), v)
+			tensor.Axpy(Float(-learn), g, v)
+			tensor.Axpy(1, v, p)
+			continue
+		}
+		// v = mu*v - learn*g, p += mu*v - learn*g
+		tensor.Scal(Float(mu), v)
+		tensor.Axpy(Float(-learn), g, v)
+		tensor.Axpy(Float(mu), v, p)
+		tensor.Axpy(Float(-learn), g, p)
+	}
+}
+
+// AdaGrad 学习率按梯度平方和衰减
+type AdaGrad struct {
+	Epsilon float64   // 默认1e-8
+	Cache   [][]Float // 梯度平方和
+}
+
+// Update ...
+func (o *AdaGrad) Update(params, grads [][]Float, learn float64) {
+	eps := defaultFloat(o.Epsilon, 1e-8)
+	if !sameShape(o.Cache, params) {
+		o.Cache = zerosLike(params)
+	}
+	for k, p := range params {
+		g, c := grads[k], o.Cache[k]
+		tensor.Zip(g, c, func(g, c Float) Float { return c + g*g })
+		adapt(p, g, c, learn, eps)
+	}
+}
+
+// RMSProp 学习率按梯度平方的滑动平均衰减
+type RMSProp struct {
+	Decay   float64   // 默认0.9
+	Epsilon float64   // 默认1e-8
+	Cache   [][]Float // 梯度平方的滑动平均
+}
+
+// Update ...
+func (o *RMSProp) Update(params, grads [][]Float, learn float64) {
+	decay := defaultFloat(o.Decay, 0.9)
+	eps := defaultFloat(o.Epsilon, 1e-8)
+	if !sameShape(o.Cache, params) {
+		o.Cache = zerosLike(params)
+	}
+	for k, p := range params {
+		g, c := grads[k], o.Cache[k]
+		tensor.Zip(g, c, func(g, c Float) Float {
+			gi := float64(g)
+			return Float(decay*float64(c) + (1-decay)*gi*gi)
+		})
+		adapt(p, g, c, learn, eps)
+	}
+}
+
+// Adam ...
+type Adam struct {
+	Beta1   float64   // 默认0.9
+	Beta2   float64   // 默认0.999
+	Epsilon float64   // 默认1e-8
+	T       int       // 已更新次数
+	M       [][]Float // 一阶矩
+	V       [][]Float // 二阶矩
+}
+
+// Update ...
+func (o *Adam) Update(params, grads [][]Float, learn float64) {
+	o.update(params, grads, learn, 0)
+}
+
+// decay为AdamW的权重衰减
+func (o *Adam) update(params, grads [][]Float, learn, decay float64) {
+	beta1 := defaultFloat(o.Beta1, 0.9)
+	beta2 := defaultFloat(o.Beta2, 0.999)
+	eps := defaultFloat(o.Epsilon, 1e-8)
+	if !sameShape(o.M, params) || !sameShape(o.V, params) {
+		o.M, o.V, o.T = zerosLike(params), zerosLike(params), 0
+	}
+
+	o.T++
+	// 偏差修正
+	c1 := 1 - math.Pow(beta1, float64(o.T))
+	c2 := 1 - math.Pow(beta2, float64(o.T))
+	for k, p := range params {
+		g, m, v := grads[k], o.M[k], o.V[k]
+		tensor.Zip(g, m, func(g, m Float) Float { return Float(beta1*float64(m) + (1-beta1)*float64(g)) })
+		tensor.Zip(g, v, func(g, v Float) Float {
+			gi := float64(g)
+			return Float(beta2*float64(v) + (1-beta2)*gi*gi)
+		})
+		tensor.Zip3(m, v, p, func(m, v, p Float) Float {
+			return p - Float(learn*(float64(m)/c1/(math.Sqrt(float64(v)/c2)+eps)+decay*float64(p)))
+		})
+	}
+}
+
+// AdamW 权重衰减与梯度分离的Adam
+type AdamW struct {
+	Adam
+	WeightDecay float64 // 默认0.01
+}
+
+// Update ...
+func (o *AdamW) Update(params, grads [][]Float, learn float64) {
+	o.update(params, grads, learn, defaultFloat(o.WeightDecay, 0.01))
+}
+
+// AdaGrad和RMSProp的修正 p -= learn*g/(sqrt(c)+eps)
+func adapt(p, g, c []Float, learn, eps float64) {
+	tensor.Zip3(g, c, p, func(g, c, p Float) Float {
+		return p - Float(learn*float64(g)/(math.Sqrt(float64(c))+eps))
+	})
+}
+
+func defaultFloat(v, def float64) float64 {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+func sameShape(a, b [][]Float) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if len(a[k]) != len(b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func zerosLike(a [][]Float) [][]Float {
+	z := make([][]Float, len(a))
+	for k := range a {
+		z[k] = make([]Float, len(a[k]))
+	}
+	return z
+}
@@ -0,0 +1,53 @@
+package nn
+
+import "sync"
+
+// 同步数据并行：samples平均分成Workers份，每个goroutine在自己的Workspace上计算梯度，
+// 再按goroutine顺序累加到o.grad，种子和Workers相同时结果相同。每个样本的误差写入diffs
+func (o *NN) trainParallel(samples []int, diffs []float64) {
+	workers := o.Workers
+	if workers > len(samples) {
+		workers = len(samples)
+	}
+
+	for k := 0; k < workers; k++ {
+		o.worker(k)
+	}
+
+	size := (len(samples) + workers - 1) / workers
+	wg := sync.WaitGroup{}
+	for k := 0; k < workers; k++ {
+		start, end := k*size, (k+1)*size
+		if end > len(samples) {
+			end = len(samples)
+		}
+		if start >= end {
+			break
+		}
+		wg.Add(1)
+		go func(ws *Workspace, start, end int) {
+			defer wg.Done()
+			o.trainBatch(ws, samples[start:end], diffs[start:end], ws.grad)
+		}(o.workers[k], start, end)
+	}
+	wg.Wait()
+
+	// 按固定顺序合并梯度
+	g := o.gradient()
+	for _, ws := range o.workers[:workers] {
+		g.add(ws.grad)
+		ws.grad.zero()
+	}
+}
+
+// 第k个goroutine训练用的Workspace，需要在启动goroutine前调用
+func (o *NN) worker(k int) *Workspace {
+	for len(o.workers) <= k {
+		o.workers = append(o.workers, nil)
+	}
+	if !o.workers[k].fits(o) {
+		o.workers[k] = o.NewWorkspace()
+		o.workers[k].grad = newGradient(o)
+	}
+	return o.workers[k]
+}
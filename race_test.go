@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package nn
+
+func init() {
+	raceEnabled = true
+}
@@ -0,0 +1,62 @@
+package nn
+
+import (
+	mrand "math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// 未设置RandSeed时，同一时刻创建的NN也使用不同的种子
+var seedCounter int64
+
+// 随机数源（splitmix64），状态只有一个uint64，便于保存
+type randSource struct {
+	state uint64
+}
+
+func (o *randSource) Seed(seed int64) {
+	o.state = uint64(seed)
+}
+
+func (o *randSource) Uint64() uint64 {
+	o.state += 0x9e3779b97f4a7c15
+	z := o.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (o *randSource) Int63() int64 {
+	return int64(o.Uint64() >> 1)
+}
+
+// 每个NN独立的随机数，用于初始化权重和打乱样本
+func (o *NN) rand() *mrand.Rand {
+	if o.rnd == nil || o.Seed {
+		seed := o.RandSeed
+		if seed == 0 || o.Seed {
+			seed = time.Now().UnixNano() + atomic.AddInt64(&seedCounter, 1)
+		}
+		o.Seed = false
+		o.src = &randSource{}
+		o.src.Seed(seed)
+		o.rnd = mrand.New(o.src)
+	}
+	return o.rnd
+}
+
+// 每轮训练的样本顺序
+func (o *NN) order(order []int) []int {
+	if len(order) != len(o.Data) {
+		order = make([]int, len(o.Data))
+	}
+	for k := range order {
+		order[k] = k
+	}
+	if o.Shuffle {
+		o.rand().Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+	}
+	return order
+}
@@ -0,0 +1,90 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+)
+
+// Regularization 一层权重的正则化，不包括偏置
+type Regularization struct {
+	L1      float64 // 损失加上L1*Σ|w|
+	L2      float64 // 损失加上L2/2*Σw²，即权重衰减
+	MaxNorm float64 // 每个神经元输入权重的L2范数不超过MaxNorm，0为不限制
+}
+
+func (o Regularization) check() error {
+	if o.L1 < 0 || o.L2 < 0 || o.MaxNorm < 0 {
+		return fmt.Errorf("L1 %v, L2 %v and MaxNorm %v must not be negative", o.L1, o.L2, o.MaxNorm)
+	}
+	return nil
+}
+
+// 第index层的正则化，默认为L1、L2和MaxNorm
+func (o *NN) regularization(index int) Regularization {
+	if index < len(o.Regularizations) && o.Regularizations[index] != nil {
+		return *o.Regularizations[index]
+	}
+	return Regularization{L1: o.L1, L2: o.L2, MaxNorm: o.MaxNorm}
+}
+
+// 正则化项，加到训练和验证的平均误差中
+func (o *NN) penalty() float64 {
+	sum := 0.0
+	for k, w := range o.Weight {
+		r := o.regularization(k)
+		if r.L1 == 0 && r.L2 == 0 {
+			continue
+		}
+		for _, row := range w {
+			for _, v := range row {
+				x := float64(v)
+				sum += r.L1*math.Abs(x) + r.L2/2*x*x
+			}
+		}
+	}
+	return sum
+}
+
+// 正则化项的梯度乘以n加到n个样本累加的梯度上
+func (o *NN) penalize(g *gradient, n int) {
+	for k, w := range o.Weight {
+		r := o.regularization(k)
+		if r.L1 == 0 && r.L2 == 0 {
+			continue
+		}
+		for i, row := range w {
+			grad := g.w[k].Row(i)
+			for j, v := range row {
+				d := r.L2 * float64(v)
+				if v > 0 {
+					d += r.L1
+				} else if v < 0 {
+					d -= r.L1
+				}
+				grad[j] += Float(d * float64(n))
+			}
+		}
+	}
+}
+
+// 修正后每个神经元的输入权重，即Weight[层]的每一列，L2范数超过MaxNorm时按比例缩小
+func (o *NN) constrain() {
+	for k, w := range o.Weight {
+		max := o.regularization(k).MaxNorm
+		if max <= 0 || len(w) == 0 {
+			continue
+		}
+		for j := range w[0] {
+			sum := 0.0
+			for _, row := range w {
+				sum += float64(row[j]) * float64(row[j])
+			}
+			if norm := math.Sqrt(sum); norm > max {
+				scale := Float(max / norm)
+				for _, row := range w {
+					row[j] *= scale
+				}
+			}
+		}
+	}
+}
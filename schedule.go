@@ -0,0 +1,163 @@
+package nn
+
+import "math"
+
+// Progress 训练进度，用于计算学习率
+type Progress struct {
+	Epoch  int // 已完成的轮数
+	Epochs int // 总轮数
+	Step   int // 已修正权重的次数
+	Steps  int // 计划修正权重的总次数
+}
+
+// Schedule 学习率调整，learn为NN.Learn
+type Schedule interface {
+	Rate(learn float64, p Progress) float64
+}
+
+// 需要每轮的指标才能调整学习率，如ReduceOnPlateau
+type metricSchedule interface {
+	Observe(metric float64)
+}
+
+// StepDecay 每StepSize轮学习率乘以Gamma
+type StepDecay struct {
+	StepSize int     // 默认10
+	Gamma    float64 // 默认0.1
+}
+
+// Rate ...
+func (o StepDecay) Rate(learn float64, p Progress) float64 {
+	size := o.StepSize
+	if size <= 0 {
+		size = 10
+	}
+	return learn * math.Pow(defaultFloat(o.Gamma, 0.1), float64(p.Epoch/size))
+}
+
+// ExponentialDecay 每轮学习率乘以Gamma
+type ExponentialDecay struct {
+	Gamma float64 // 默认0.95
+}
+
+// Rate ...
+func (o ExponentialDecay) Rate(learn float64, p Progress) float64 {
+	return learn * math.Pow(defaultFloat(o.Gamma, 0.95), float64(p.Epoch))
+}
+
+// CosineAnnealing 余弦退火，每T0轮重启一次，重启周期每次乘以TMult
+type CosineAnnealing struct {
+	T0       int     // 第一个周期的轮数，默认10
+	TMult    int     // 周期倍数，默认1
+	MinLearn float64 // 最小学习率
+}
+
+// Rate ...
+func (o CosineAnnealing) Rate(learn float64, p Progress) float64 {
+	t, period := p.Epoch, o.T0
+	if period <= 0 {
+		period = 10
+	}
+	mult := o.TMult
+	if mult <= 0 {
+		mult = 1
+	}
+	for t >= period {
+		t -= period
+		period *= mult
+	}
+	return o.MinLearn + (learn-o.MinLearn)*(1+math.Cos(math.Pi*float64(t)/float64(period)))/2
+}
+
+// LinearWarmup 前Steps次修正学习率线性增加到learn，之后使用After（nil为不变）
+type LinearWarmup struct {
+	Steps int
+	After Schedule
+}
+
+// Rate ...
+func (o LinearWarmup) Rate(learn float64, p Progress) float64 {
+	if p.Step < o.Steps {
+		return learn * float64(p.Step+1) / float64(o.Steps)
+	}
+	if o.After != nil {
+		return o.After.Rate(learn, p)
+	}
+	return learn
+}
+
+// Observe After需要指标时转给After
+func (o LinearWarmup) Observe(metric float64) {
+	if after, ok := o.After.(metricSchedule); ok {
+		after.Observe(metric)
+	}
+}
+
+// OneCycle 学习率先从learn/DivFactor余弦增加到learn，再余弦减少到learn/DivFactor/FinalDiv
+type OneCycle struct {
+	PctStart  float64 // 增加阶段占总次数的比例，默认0.3
+	DivFactor float64 // 默认25
+	FinalDiv  float64 // 默认1e4
+}
+
+// Rate ...
+func (o OneCycle) Rate(learn float64, p Progress) float64 {
+	initial := learn / defaultFloat(o.DivFactor, 25)
+	final := initial / defaultFloat(o.FinalDiv, 1e4)
+	up := defaultFloat(o.PctStart, 0.3) * float64(p.Steps)
+	step := float64(p.Step)
+	if step < up {
+		return cosineBetween(initial, learn, step/up)
+	}
+	down := float64(p.Steps) - up
+	if down <= 0 {
+		return final
+	}
+	return cosineBetween(learn, final, math.Min((step-up)/down, 1))
+}
+
+// 从a余弦变化到b，pct为[0,1]
+func cosineBetween(a, b, pct float64) float64 {
+	return b + (a-b)*(1+math.Cos(math.Pi*pct))/2
+}
+
+// ReduceOnPlateau 指标（有Validation时为验证集误差，否则为训练误差）连续Patience轮没有改进时学习率乘以Factor
+type ReduceOnPlateau struct {
+	Factor   float64 // 默认0.1
+	Patience int     // 默认10
+	MinDelta float64 // 改进超过MinDelta才算改进
+	MinLearn float64 // 最小学习率
+
+	Scale float64 // 当前学习率的倍数
+	Best  float64 // 最好的指标
+	Wait  int     // 连续没有改进的轮数
+	Seen  bool    // 是否已有指标
+}
+
+// Rate ...
+func (o *ReduceOnPlateau) Rate(learn float64, p Progress) float64 {
+	if o.Scale == 0 {
+		o.Scale = 1
+	}
+	return math.Max(learn*o.Scale, o.MinLearn)
+}
+
+// Observe 每轮结束时的指标，越小越好
+func (o *ReduceOnPlateau) Observe(metric float64) {
+	if o.Scale == 0 {
+		o.Scale = 1
+	}
+	if !o.Seen || metric < o.Best-o.MinDelta {
+		o.Best, o.Wait, o.Seen = metric, 0, true
+		return
+	}
+	o.Wait++
+	patience := o.Patience
+	if patience <= 0 {
+		patience = 10
+	}
+	if o.Wait >= patience {
+		o.Scale *= defaultFloat(o.Factor, 0.1)
+		o.Wait = 0
+	}
+}
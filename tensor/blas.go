@@ -0,0 +1,254 @@
+package tensor
+
+import (
+	"fmt"
+	"math"
+)
+
+// 分块大小，一块的行或列放在L1缓存中
+const blockSize = 256
+
+// Gemm c = alpha*op(a)*op(b) + beta*c，op为转置或不变，
+// 每个元素按内积维度的顺序累加到beta*c上，结果与逐个元素计算相同
+func Gemm(transA, transB bool, alpha Float, a, b *Tensor, beta Float, c *Tensor) {
+	if transA {
+		a = a.T()
+	}
+	if transB {
+		b = b.T()
+	}
+	if a.Dims() != 2 || b.Dims() != 2 || c.Dims() != 2 || a.Shape[1] != b.Shape[0] || c.Shape[0] != a.Shape[0] || c.Shape[1] != b.Shape[1] {
+		panic(fmt.Sprintf("tensor: gemm %v x %v -> %v", a.Shape, b.Shape, c.Shape))
+	}
+	if !rowMajor(c) {
+		panic("tensor: gemm output must have contiguous rows")
+	}
+	scaleRows(c, beta)
+
+	switch {
+	case rowMajor(a) && rowMajor(b):
+		gemmNN(alpha, a, b, c)
+	case transA && rowMajor(a.T()) && rowMajor(b):
+		gemmTN(alpha, a.T(), b, c)
+	case transB && rowMajor(a) && rowMajor(b.T()):
+		gemmNT(alpha, a, b.T(), c)
+	default:
+		for s := 0; s < c.Shape[0]; s++ {
+			cc := c.Row(s)
+			for j := range cc {
+				var sum Float
+				for k := 0; k < a.Shape[1]; k++ {
+					sum += a.Data[s*a.Strides[0]+k*a.Strides[1]] * b.Data[k*b.Strides[0]+j*b.Strides[1]]
+				}
+				cc[j] += alpha * sum
+			}
+		}
+	}
+}
+
+// c += alpha*a·b，按a的列和b的列分块
+func gemmNN(alpha Float, a, b, c *Tensor) {
+	rows, inner, cols := a.Shape[0], a.Shape[1], b.Shape[1]
+	for k0 := 0; k0 < inner; k0 += blockSize {
+		k1 := minInt(k0+blockSize, inner)
+		for j0 := 0; j0 < cols; j0 += blockSize {
+			j1 := minInt(j0+blockSize, cols)
+			for s := 0; s < rows; s++ {
+				cc := c.Row(s)[j0:j1]
+				for k, v := range a.Row(s)[k0:k1] {
+					row := b.Row(k0 + k)[j0:j1]
+					v *= alpha
+					for j := range cc {
+						cc[j] += v * row[j]
+					}
+				}
+			}
+		}
+	}
+}
+
+// c += alpha*aᵀ·b，a和b的行数相同，按行分块
+func gemmTN(alpha Float, a, b, c *Tensor) {
+	rows := a.Shape[0]
+	for s0 := 0; s0 < rows; s0 += blockSize {
+		s1 := minInt(s0+blockSize, rows)
+		for i := 0; i < a.Shape[1]; i++ {
+			cc := c.Row(i)
+			for s := s0; s < s1; s++ {
+				v, row := alpha*a.Row(s)[i], b.Row(s)
+				for j := range cc {
+					cc[j] += row[j] * v
+				}
+			}
+		}
+	}
+}
+
+// c += alpha*a·bᵀ，c[s][i]为a的第s行与b的第i行的点积，按b的行分块
+func gemmNT(alpha Float, a, b, c *Tensor) {
+	for i0 := 0; i0 < b.Shape[0]; i0 += blockSize {
+		i1 := minInt(i0+blockSize, b.Shape[0])
+		for s := 0; s < a.Shape[0]; s++ {
+			x, cc := a.Row(s), c.Row(s)
+			for i := i0; i < i1; i++ {
+				cc[i] += alpha * Dot(x, b.Row(i))
+			}
+		}
+	}
+}
+
+// Gemv y = alpha*op(a)*x + beta*y，op为转置或不变
+func Gemv(trans bool, alpha Float, a *Tensor, x []Float, beta Float, y []Float) {
+	if a.Dims() != 2 || !rowMajor(a) {
+		panic(fmt.Sprintf("tensor: gemv of shape %v strides %v", a.Shape, a.Strides))
+	}
+	rows, cols := a.Shape[0], a.Shape[1]
+	if trans {
+		rows, cols = cols, rows
+	}
+	if len(x) != cols || len(y) != rows {
+		panic(fmt.Sprintf("tensor: gemv %v x %d -> %d", a.Shape, len(x), len(y)))
+	}
+	scale(y, beta)
+
+	if !trans {
+		for i := range y {
+			y[i] += alpha * Dot(x, a.Row(i))
+		}
+		return
+	}
+	// 按y分块，每块y留在缓存中逐行累加
+	for j0 := 0; j0 < len(y); j0 += blockSize {
+		j1 := minInt(j0+blockSize, len(y))
+		yy := y[j0:j1]
+		for i, v := range x {
+			row := a.Row(i)[j0:j1]
+			v *= alpha
+			for j := range yy {
+				yy[j] += v * row[j]
+			}
+		}
+	}
+}
+
+// Ger a += alpha*x⊗y，a[i][j] += alpha*x[i]*y[j]
+func Ger(alpha Float, x, y []Float, a *Tensor) {
+	if a.Dims() != 2 || !rowMajor(a) || a.Shape[0] != len(x) || a.Shape[1] != len(y) {
+		panic(fmt.Sprintf("tensor: ger %d x %d -> %v", len(x), len(y), a.Shape))
+	}
+	for i, v := range x {
+		Axpy(alpha*v, y, a.Row(i))
+	}
+}
+
+// Dot x·y
+func Dot(x, y []Float) Float {
+	var sum Float
+	for i, v := range x {
+		sum += v * y[i]
+	}
+	return sum
+}
+
+// Axpy y += alpha*x
+func Axpy(alpha Float, x, y []Float) {
+	y = y[:len(x)]
+	for i, v := range x {
+		y[i] += v * alpha
+	}
+}
+
+// Scal x *= alpha
+func Scal(alpha Float, x []Float) {
+	for i := range x {
+		x[i] *= alpha
+	}
+}
+
+// Sum x的和
+func Sum(x []Float) Float {
+	var sum Float
+	for _, v := range x {
+		sum += v
+	}
+	return sum
+}
+
+// Max x中最大的值，x为空时为-Inf
+func Max(x []Float) Float {
+	max := Float(math.Inf(-1))
+	for _, v := range x {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// Argmax x中最大值的位置，相同时取第一个
+func Argmax(x []Float) int {
+	index := 0
+	for i, v := range x {
+		if v > x[index] {
+			index = i
+		}
+	}
+	return index
+}
+
+// Zip y[i] = f(x[i], y[i])
+func Zip(x, y []Float, f func(x, y Float) Float) {
+	y = y[:len(x)]
+	for i, v := range x {
+		y[i] = f(v, y[i])
+	}
+}
+
+// Zip3 z[i] = f(x[i], y[i], z[i])
+func Zip3(x, y, z []Float, f func(x, y, z Float) Float) {
+	y, z = y[:len(x)], z[:len(x)]
+	for i, v := range x {
+		z[i] = f(v, y[i], z[i])
+	}
+}
+
+// SumZip f(x[i], y[i])的和，用float64累加
+func SumZip(x, y []Float, f func(x, y Float) float64) float64 {
+	y = y[:len(x)]
+	sum := 0.0
+	for i, v := range x {
+		sum += f(v, y[i])
+	}
+	return sum
+}
+
+// y = beta*y，beta为0时清零，为1时不变
+func scale(y []Float, beta Float) {
+	switch beta {
+	case 1:
+	case 0:
+		for i := range y {
+			y[i] = 0
+		}
+	default:
+		Scal(beta, y)
+	}
+}
+
+func scaleRows(c *Tensor, beta Float) {
+	for s := 0; s < c.Shape[0]; s++ {
+		scale(c.Row(s), beta)
+	}
+}
+
+// 每一行是否连续
+func rowMajor(t *Tensor) bool {
+	return t.Dims() == 2 && (t.Strides[1] == 1 || t.Shape[1] <= 1)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
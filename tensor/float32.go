@@ -0,0 +1,13 @@
+//go:build float32
+// +build float32
+
+package tensor
+
+// Float 元素类型，-tags float32时为float32，内存和带宽减半
+type Float = float32
+
+// Bits 元素类型的位数
+const Bits = 32
+
+// Precision 元素类型的名称
+const Precision = "float32"
@@ -0,0 +1,13 @@
+//go:build !float32
+// +build !float32
+
+package tensor
+
+// Float 元素类型，默认为float64，编译时加 -tags float32 使用float32
+type Float = float64
+
+// Bits 元素类型的位数
+const Bits = 64
+
+// Precision 元素类型的名称
+const Precision = "float64"
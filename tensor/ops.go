@@ -0,0 +1,221 @@
+package tensor
+
+import (
+	"fmt"
+	"math"
+)
+
+// BroadcastShape 按numpy规则广播后的形状：从最后一维对齐，每一维相同或其中一个为1
+func BroadcastShape(a, b []int) ([]int, error) {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	shape := make([]int, n)
+	for i := 1; i <= n; i++ {
+		x, y := 1, 1
+		if i <= len(a) {
+			x = a[len(a)-i]
+		}
+		if i <= len(b) {
+			y = b[len(b)-i]
+		}
+		switch {
+		case x == y || y == 1:
+			shape[n-i] = x
+		case x == 1:
+			shape[n-i] = y
+		default:
+			return nil, fmt.Errorf("tensor: cannot broadcast %v and %v", a, b)
+		}
+	}
+	return shape, nil
+}
+
+// 广播到shape的视图，广播的维度步长为0
+func (t *Tensor) broadcastTo(shape []int) *Tensor {
+	v := &Tensor{Shape: shape, Strides: make([]int, len(shape)), Data: t.Data}
+	for i := 1; i <= len(t.Shape); i++ {
+		if t.Shape[len(t.Shape)-i] == shape[len(shape)-i] {
+			v.Strides[len(shape)-i] = t.Strides[len(t.Shape)-i]
+		}
+	}
+	return v
+}
+
+// Binary 广播后逐个元素计算f(a,b)，返回新的张量
+func Binary(a, b *Tensor, f func(x, y Float) Float) *Tensor {
+	shape, err := BroadcastShape(a.Shape, b.Shape)
+	if err != nil {
+		panic(err.Error())
+	}
+	c := New(shape...)
+	av, bv := a.broadcastTo(shape), b.broadcastTo(shape)
+	offsets := bv.offsets()
+	i := 0
+	av.each(func(off int) {
+		c.Data[i] = f(av.Data[off], bv.Data[offsets[i]])
+		i++
+	})
+	return c
+}
+
+// 按行优先顺序每个元素的位置
+func (t *Tensor) offsets() []int {
+	v := make([]int, 0, t.Size())
+	t.each(func(off int) { v = append(v, off) })
+	return v
+}
+
+// Add a+b
+func Add(a, b *Tensor) *Tensor { return Binary(a, b, func(x, y Float) Float { return x + y }) }
+
+// Sub a-b
+func Sub(a, b *Tensor) *Tensor { return Binary(a, b, func(x, y Float) Float { return x - y }) }
+
+// Mul 逐个元素相乘
+func Mul(a, b *Tensor) *Tensor { return Binary(a, b, func(x, y Float) Float { return x * y }) }
+
+// Div 逐个元素相除
+func Div(a, b *Tensor) *Tensor { return Binary(a, b, func(x, y Float) Float { return x / y }) }
+
+// Assign 复制src到t，src广播到t的形状
+func (t *Tensor) Assign(src *Tensor) *Tensor {
+	shape, err := BroadcastShape(t.Shape, src.Shape)
+	if err != nil || !sameInts(shape, t.Shape) {
+		panic(fmt.Sprintf("tensor: cannot assign %v to %v", src.Shape, t.Shape))
+	}
+	offsets := src.broadcastTo(t.Shape).offsets()
+	i := 0
+	t.each(func(off int) {
+		t.Data[off] = src.Data[offsets[i]]
+		i++
+	})
+	return t
+}
+
+// Apply 每个元素替换为f(x)
+func (t *Tensor) Apply(f func(x Float) Float) *Tensor {
+	t.each(func(off int) { t.Data[off] = f(t.Data[off]) })
+	return t
+}
+
+// Map f(x)的新张量
+func (t *Tensor) Map(f func(x Float) Float) *Tensor {
+	return t.Clone().Apply(f)
+}
+
+// Fill 全部设为v
+func (t *Tensor) Fill(v Float) *Tensor {
+	return t.Apply(func(Float) Float { return v })
+}
+
+// Scale 每个元素乘以alpha
+func (t *Tensor) Scale(alpha Float) *Tensor {
+	return t.Apply(func(x Float) Float { return alpha * x })
+}
+
+// Sum 所有元素的和
+func (t *Tensor) Sum() Float {
+	var sum Float
+	t.each(func(off int) { sum += t.Data[off] })
+	return sum
+}
+
+// Max 最大的元素
+func (t *Tensor) Max() Float {
+	max := Float(math.Inf(-1))
+	t.each(func(off int) { max = Float(math.Max(float64(max), float64(t.Data[off]))) })
+	return max
+}
+
+// Argmax 最大元素按行优先顺序的位置，相同时取第一个
+func (t *Tensor) Argmax() int {
+	index, i, max := 0, 0, Float(math.Inf(-1))
+	t.each(func(off int) {
+		if t.Data[off] > max {
+			index, max = i, t.Data[off]
+		}
+		i++
+	})
+	return index
+}
+
+// SumAxis 沿axis求和，结果少一维
+func (t *Tensor) SumAxis(axis int) *Tensor {
+	return t.reduceAxis(axis, 0, func(acc, x Float, i int) Float { return acc + x })
+}
+
+// MaxAxis 沿axis取最大值，结果少一维
+func (t *Tensor) MaxAxis(axis int) *Tensor {
+	return t.reduceAxis(axis, Float(math.Inf(-1)), func(acc, x Float, i int) Float { return Float(math.Max(float64(acc), float64(x))) })
+}
+
+// ArgmaxAxis 沿axis最大值的位置，结果少一维，相同时取第一个
+func (t *Tensor) ArgmaxAxis(axis int) *Tensor {
+	r, max := New(t.reducedShape(axis)...).Fill(-1), New(t.reducedShape(axis)...)
+	t.reduceEach(axis, func(dst, src, i int) {
+		if r.Data[dst] < 0 || t.Data[src] > max.Data[dst] {
+			r.Data[dst], max.Data[dst] = Float(i), t.Data[src]
+		}
+	})
+	return r
+}
+
+// 沿axis归约，f的i为元素在axis上的位置
+func (t *Tensor) reduceAxis(axis int, init Float, f func(acc, x Float, i int) Float) *Tensor {
+	r := New(t.reducedShape(axis)...).Fill(init)
+	t.reduceEach(axis, func(dst, src, i int) {
+		r.Data[dst] = f(r.Data[dst], t.Data[src], i)
+	})
+	return r
+}
+
+// 去掉axis后的形状
+func (t *Tensor) reducedShape(axis int) []int {
+	if axis < 0 || axis >= len(t.Shape) {
+		panic(fmt.Sprintf("tensor: axis %d of shape %v", axis, t.Shape))
+	}
+	return append(append([]int{}, t.Shape[:axis]...), t.Shape[axis+1:]...)
+}
+
+// 遍历每个元素，dst为归约结果中的位置，src为t中的位置，i为在axis上的位置
+func (t *Tensor) reduceEach(axis int, f func(dst, src, i int)) {
+	if t.Size() == 0 {
+		return
+	}
+	idx := make([]int, len(t.Shape))
+	for {
+		src, dst := 0, 0
+		for k, v := range idx {
+			src += v * t.Strides[k]
+			if k != axis {
+				dst = dst*t.Shape[k] + v
+			}
+		}
+		f(dst, src, idx[axis])
+
+		k := len(idx) - 1
+		for ; k >= 0; k-- {
+			if idx[k]++; idx[k] < t.Shape[k] {
+				break
+			}
+			idx[k] = 0
+		}
+		if k < 0 {
+			return
+		}
+	}
+}
+
+func sameInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,13 @@
+package tensor
+
+import mrand "math/rand"
+
+// RandUniform 按行优先顺序填充[-limit,limit)均匀分布的随机数
+func (t *Tensor) RandUniform(rnd *mrand.Rand, limit Float) *Tensor {
+	return t.Apply(func(Float) Float { return Float((rnd.Float64()*2 - 1) * float64(limit)) })
+}
+
+// RandNormal 按行优先顺序填充均值为0、标准差为std的正态分布随机数
+func (t *Tensor) RandNormal(rnd *mrand.Rand, std Float) *Tensor {
+	return t.Apply(func(Float) Float { return Float(rnd.NormFloat64() * float64(std)) })
+}
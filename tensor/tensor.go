@@ -0,0 +1,254 @@
+// Package tensor 纯Go的张量运算，行优先存储，支持视图、广播、GEMM/GEMV和归约
+package tensor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tensor 张量，第i维的步长为Strides[i]，元素(i0,i1,...)为Data[i0*Strides[0]+i1*Strides[1]+...]，
+// 视图与原张量共用Data
+type Tensor struct {
+	Shape   []int
+	Strides []int
+	Data    []Float
+}
+
+// New 全部为0的连续张量
+func New(shape ...int) *Tensor {
+	return FromSlice(make([]Float, size(shape)), shape...)
+}
+
+// FromSlice 使用data作为连续张量的元素，不复制
+func FromSlice(data []Float, shape ...int) *Tensor {
+	if len(data) != size(shape) {
+		panic(fmt.Sprintf("tensor: %d elements for shape %v", len(data), shape))
+	}
+	shape = append([]int{}, shape...)
+	return &Tensor{Shape: shape, Strides: strides(shape), Data: data}
+}
+
+// FromRows 复制rows到连续的矩阵，每行宽度必须相同
+func FromRows(rows [][]Float) *Tensor {
+	cols := 0
+	if len(rows) > 0 {
+		cols = len(rows[0])
+	}
+	t := New(len(rows), cols)
+	for i, v := range rows {
+		if len(v) != cols {
+			panic(fmt.Sprintf("tensor: row %d has %d elements, want %d", i, len(v), cols))
+		}
+		copy(t.Data[i*cols:], v)
+	}
+	return t
+}
+
+// 行优先的步长
+func strides(shape []int) []int {
+	s := make([]int, len(shape))
+	n := 1
+	for i := len(shape) - 1; i >= 0; i-- {
+		s[i] = n
+		n *= shape[i]
+	}
+	return s
+}
+
+func size(shape []int) int {
+	n := 1
+	for _, v := range shape {
+		if v < 0 {
+			panic(fmt.Sprintf("tensor: negative dimension in %v", shape))
+		}
+		n *= v
+	}
+	return n
+}
+
+// Dims 维数
+func (t *Tensor) Dims() int { return len(t.Shape) }
+
+// Size 元素个数
+func (t *Tensor) Size() int { return size(t.Shape) }
+
+// IsContiguous 是否为行优先连续存储
+func (t *Tensor) IsContiguous() bool {
+	n := 1
+	for i := len(t.Shape) - 1; i >= 0; i-- {
+		if t.Shape[i] != 1 && t.Strides[i] != n {
+			return false
+		}
+		n *= t.Shape[i]
+	}
+	return true
+}
+
+func (t *Tensor) offset(idx []int) int {
+	if len(idx) != len(t.Shape) {
+		panic(fmt.Sprintf("tensor: %d indices for shape %v", len(idx), t.Shape))
+	}
+	off := 0
+	for i, v := range idx {
+		if v < 0 || v >= t.Shape[i] {
+			panic(fmt.Sprintf("tensor: index %v out of range %v", idx, t.Shape))
+		}
+		off += v * t.Strides[i]
+	}
+	return off
+}
+
+// At ...
+func (t *Tensor) At(idx ...int) Float { return t.Data[t.offset(idx)] }
+
+// Set ...
+func (t *Tensor) Set(v Float, idx ...int) { t.Data[t.offset(idx)] = v }
+
+// Clone 复制为连续张量
+func (t *Tensor) Clone() *Tensor {
+	c := New(t.Shape...)
+	c.Assign(t)
+	return c
+}
+
+// Reshape 同样元素的新形状，t必须连续，返回视图
+func (t *Tensor) Reshape(shape ...int) *Tensor {
+	if !t.IsContiguous() {
+		panic("tensor: reshape of non-contiguous tensor")
+	}
+	if size(shape) != t.Size() {
+		panic(fmt.Sprintf("tensor: cannot reshape %v to %v", t.Shape, shape))
+	}
+	return FromSlice(t.Data[:t.Size()], shape...)
+}
+
+// Transpose 按axes重新排列维度，返回视图，没有axes时反转所有维度
+func (t *Tensor) Transpose(axes ...int) *Tensor {
+	if len(axes) == 0 {
+		for i := len(t.Shape) - 1; i >= 0; i-- {
+			axes = append(axes, i)
+		}
+	}
+	if len(axes) != len(t.Shape) {
+		panic(fmt.Sprintf("tensor: transpose axes %v for shape %v", axes, t.Shape))
+	}
+	v := &Tensor{Shape: make([]int, len(axes)), Strides: make([]int, len(axes)), Data: t.Data}
+	seen := make([]bool, len(axes))
+	for i, a := range axes {
+		if a < 0 || a >= len(axes) || seen[a] {
+			panic(fmt.Sprintf("tensor: transpose axes %v", axes))
+		}
+		seen[a] = true
+		v.Shape[i], v.Strides[i] = t.Shape[a], t.Strides[a]
+	}
+	return v
+}
+
+// T 矩阵的转置视图
+func (t *Tensor) T() *Tensor {
+	if len(t.Shape) != 2 {
+		panic(fmt.Sprintf("tensor: T of shape %v", t.Shape))
+	}
+	return t.Transpose(1, 0)
+}
+
+// Slice 第axis维的[start,end)，返回视图
+func (t *Tensor) Slice(axis, start, end int) *Tensor {
+	if axis < 0 || axis >= len(t.Shape) || start < 0 || end > t.Shape[axis] || start > end {
+		panic(fmt.Sprintf("tensor: slice %d [%d:%d] of shape %v", axis, start, end, t.Shape))
+	}
+	v := &Tensor{Shape: append([]int{}, t.Shape...), Strides: append([]int{}, t.Strides...)}
+	v.Shape[axis] = end - start
+	if v.Size() > 0 {
+		v.Data = t.Data[start*t.Strides[axis]:]
+	}
+	return v
+}
+
+// Index 第一维的第i个元素，返回少一维的视图
+func (t *Tensor) Index(i int) *Tensor {
+	if len(t.Shape) == 0 || i < 0 || i >= t.Shape[0] {
+		panic(fmt.Sprintf("tensor: index %d of shape %v", i, t.Shape))
+	}
+	return &Tensor{Shape: append([]int{}, t.Shape[1:]...), Strides: append([]int{}, t.Strides[1:]...), Data: t.Data[i*t.Strides[0]:]}
+}
+
+// Row 矩阵第i行的元素，行必须连续，与t共用内存
+func (t *Tensor) Row(i int) []Float {
+	if len(t.Shape) != 2 || (t.Strides[1] != 1 && t.Shape[1] > 1) {
+		panic(fmt.Sprintf("tensor: row of shape %v strides %v", t.Shape, t.Strides))
+	}
+	start := i * t.Strides[0]
+	return t.Data[start : start+t.Shape[1] : start+t.Shape[1]]
+}
+
+// Rows 矩阵每一行的元素，与t共用内存
+func (t *Tensor) Rows() [][]Float {
+	rows := make([][]Float, t.Shape[0])
+	for i := range rows {
+		rows[i] = t.Row(i)
+	}
+	return rows
+}
+
+// 按行优先顺序遍历每个元素的位置
+func (t *Tensor) each(f func(off int)) {
+	if t.Size() == 0 {
+		return
+	}
+	if t.IsContiguous() {
+		for i := 0; i < t.Size(); i++ {
+			f(i)
+		}
+		return
+	}
+	idx := make([]int, len(t.Shape))
+	for {
+		off := 0
+		for i, v := range idx {
+			off += v * t.Strides[i]
+		}
+		f(off)
+
+		i := len(idx) - 1
+		for ; i >= 0; i-- {
+			if idx[i]++; idx[i] < t.Shape[i] {
+				break
+			}
+			idx[i] = 0
+		}
+		if i < 0 {
+			return
+		}
+	}
+}
+
+// Values 按行优先顺序复制所有元素
+func (t *Tensor) Values() []Float {
+	v := make([]Float, 0, t.Size())
+	t.each(func(off int) { v = append(v, t.Data[off]) })
+	return v
+}
+
+func (t *Tensor) String() string {
+	values := t.Values()
+	b := strings.Builder{}
+	var write func(dim, start int)
+	write = func(dim, start int) {
+		if dim == len(t.Shape) {
+			fmt.Fprint(&b, values[start])
+			return
+		}
+		n := size(t.Shape[dim+1:])
+		b.WriteByte('[')
+		for i := 0; i < t.Shape[dim]; i++ {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			write(dim+1, start+i*n)
+		}
+		b.WriteByte(']')
+	}
+	write(0, 0)
+	return b.String()
+}
@@ -0,0 +1,206 @@
+package tensor
+
+import (
+	"fmt"
+	mrand "math/rand"
+	"testing"
+)
+
+// go test nn/tensor -run Test_视图 -v -count=1
+func Test_视图(t *testing.T) {
+	a := FromSlice([]Float{1, 2, 3, 4, 5, 6}, 2, 3)
+	if a.At(1, 2) != 6 || !a.IsContiguous() || a.Size() != 6 || a.Dims() != 2 {
+		t.Fatal("at:", a)
+	}
+	if s := a.T().String(); s != "[[1 4] [2 5] [3 6]]" {
+		t.Fatal("T:", s)
+	}
+	if a.T().IsContiguous() {
+		t.Fatal("transpose is contiguous")
+	}
+	if s := a.Slice(1, 1, 3).String(); s != "[[2 3] [5 6]]" {
+		t.Fatal("slice:", s)
+	}
+	if s := a.Index(1).String(); s != "[4 5 6]" {
+		t.Fatal("index:", s)
+	}
+	if s := a.Reshape(3, 2).String(); s != "[[1 2] [3 4] [5 6]]" {
+		t.Fatal("reshape:", s)
+	}
+
+	// 视图与原张量共用内存
+	a.T().Set(10, 2, 1)
+	if a.At(1, 2) != 10 || a.Row(1)[2] != 10 {
+		t.Fatal("view set:", a)
+	}
+	c := a.T().Clone()
+	c.Set(0, 0, 0)
+	if !c.IsContiguous() || a.At(0, 0) != 1 || fmt.Sprint(c.Values()) != "[0 4 2 5 3 10]" {
+		t.Fatal("clone:", c)
+	}
+	if s := FromRows([][]Float{{1, 2}, {3, 4}}).Transpose().String(); s != "[[1 3] [2 4]]" {
+		t.Fatal("from rows:", s)
+	}
+}
+
+// go test nn/tensor -run Test_广播 -v -count=1
+func Test_广播(t *testing.T) {
+	a := FromSlice([]Float{1, 2, 3, 4, 5, 6}, 2, 3)
+	if s := Add(a, FromSlice([]Float{10, 20, 30}, 3)).String(); s != "[[11 22 33] [14 25 36]]" {
+		t.Fatal("add row:", s)
+	}
+	if s := Mul(a, FromSlice([]Float{2, 3}, 2, 1)).String(); s != "[[2 4 6] [12 15 18]]" {
+		t.Fatal("mul column:", s)
+	}
+	if s := Sub(FromSlice([]Float{1, 2}, 2, 1), FromSlice([]Float{1, 2, 3}, 1, 3)).String(); s != "[[0 -1 -2] [1 0 -1]]" {
+		t.Fatal("outer sub:", s)
+	}
+	if s := Div(a.T(), FromSlice([]Float{1, 2}, 2)).String(); s != "[[1 2] [2 2.5] [3 3]]" {
+		t.Fatal("div transposed:", s)
+	}
+	if _, err := BroadcastShape([]int{2, 3}, []int{2}); err == nil {
+		t.Fatal("broadcast not checked")
+	}
+
+	b := New(2, 3).Assign(FromSlice([]Float{7, 8, 9}, 3))
+	if s := b.String(); s != "[[7 8 9] [7 8 9]]" {
+		t.Fatal("assign:", s)
+	}
+	if s := b.Map(func(x Float) Float { return x - 7 }).Scale(2).String(); s != "[[0 2 4] [0 2 4]]" {
+		t.Fatal("map:", s)
+	}
+}
+
+// go test nn/tensor -run Test_归约 -v -count=1
+func Test_归约(t *testing.T) {
+	a := FromSlice([]Float{1, 9, 3, 7, 5, 6}, 2, 3)
+	if a.Sum() != 31 || a.Max() != 9 || a.Argmax() != 1 {
+		t.Fatal("reduce:", a.Sum(), a.Max(), a.Argmax())
+	}
+	if s := a.SumAxis(0).String(); s != "[8 14 9]" {
+		t.Fatal("sum axis 0:", s)
+	}
+	if s := a.SumAxis(1).String(); s != "[13 18]" {
+		t.Fatal("sum axis 1:", s)
+	}
+	if s := a.MaxAxis(1).String(); s != "[9 7]" {
+		t.Fatal("max axis:", s)
+	}
+	if s := a.ArgmaxAxis(1).String(); s != "[1 0]" {
+		t.Fatal("argmax axis:", s)
+	}
+	if s := a.T().ArgmaxAxis(1).String(); s != "[1 0 1]" {
+		t.Fatal("argmax transposed:", s)
+	}
+	if Argmax([]Float{1, 3, 3}) != 1 || Max([]Float{-1, -2}) != -1 || Sum([]Float{1, 2}) != 3 {
+		t.Fatal("vector reduce")
+	}
+}
+
+// go test nn/tensor -run Test_矩阵乘法 -v -count=1
+func Test_矩阵乘法(t *testing.T) {
+	rnd := mrand.New(mrand.NewSource(1))
+	random := func(shape ...int) *Tensor { return New(shape...).RandNormal(rnd, 1) }
+	// 超过blockSize的大小，检查分块
+	m, k, n := 3, 300, 260
+	tol := Float(1e-9)
+	if Precision == "float32" {
+		tol = 1e-3
+	}
+	for _, trans := range [][2]bool{{false, false}, {true, false}, {false, true}, {true, true}} {
+		a, b := random(m, k), random(k, n)
+		if trans[0] {
+			a = a.T().Clone()
+		}
+		if trans[1] {
+			b = b.T().Clone()
+		}
+		c := random(m, n)
+		want := c.Clone()
+		Gemm(trans[0], trans[1], 0.5, a, b, 2, c)
+		for i := 0; i < m; i++ {
+			for j := 0; j < n; j++ {
+				var sum Float
+				for l := 0; l < k; l++ {
+					var x, y Float
+					if trans[0] {
+						x = a.At(l, i)
+					} else {
+						x = a.At(i, l)
+					}
+					if trans[1] {
+						y = b.At(j, l)
+					} else {
+						y = b.At(l, j)
+					}
+					sum += x * y
+				}
+				if d := c.At(i, j) - (2*want.At(i, j) + 0.5*sum); d > tol || d < -tol {
+					t.Fatal("gemm", trans, i, j, c.At(i, j), 2*want.At(i, j)+0.5*sum)
+				}
+			}
+		}
+	}
+
+	// 每个元素按顺序累加到c上，与逐个计算完全相同
+	a, b, c := random(2, 3), random(3, 2), random(2, 2)
+	want := c.Clone()
+	Gemm(false, false, 1, a, b, 1, c)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			v := want.At(i, j)
+			for l := 0; l < 3; l++ {
+				v += a.At(i, l) * b.At(l, j)
+			}
+			if c.At(i, j) != v {
+				t.Fatal("gemm order:", c.At(i, j), v)
+			}
+		}
+	}
+
+	w := FromSlice([]Float{1, 2, 3, 4, 5, 6}, 2, 3)
+	y := []Float{1, 1, 1}
+	Gemv(true, 1, w, []Float{1, 2}, 1, y)
+	if fmt.Sprint(y) != "[10 13 16]" {
+		t.Fatal("gemv trans:", y)
+	}
+	z := []Float{1, 1}
+	Gemv(false, 2, w, []Float{1, 0, 1}, 0, z)
+	if fmt.Sprint(z) != "[8 20]" {
+		t.Fatal("gemv:", z)
+	}
+	Ger(1, []Float{1, 2}, []Float{1, 0, -1}, w)
+	if w.String() != "[[2 2 2] [6 5 4]]" {
+		t.Fatal("ger:", w)
+	}
+	x := []Float{1, 2}
+	Axpy(2, []Float{1, 1}, x)
+	Scal(0.5, x)
+	if fmt.Sprint(x) != "[1.5 2]" || Dot(x, x) != 6.25 {
+		t.Fatal("axpy:", x)
+	}
+	Zip([]Float{1, 2}, x, func(a, b Float) Float { return a * b })
+	Zip3([]Float{1, 1}, []Float{2, 3}, x, func(a, b, c Float) Float { return a + b*c })
+	sum := SumZip(x, []Float{1, 2}, func(a, b Float) float64 { return float64(a - b) })
+	if fmt.Sprint(x) != "[4 13]" || sum != 14 {
+		t.Fatal("zip:", x, sum)
+	}
+}
+
+// go test nn/tensor -run Test_随机 -v -count=1
+func Test_随机(t *testing.T) {
+	a := New(100, 10).RandUniform(mrand.New(mrand.NewSource(1)), 0.5)
+	b := New(100, 10).RandUniform(mrand.New(mrand.NewSource(1)), 0.5)
+	if a.String() != b.String() {
+		t.Fatal("not deterministic")
+	}
+	if a.Max() >= 0.5 || a.Map(func(x Float) Float { return -x }).Max() > 0.5 {
+		t.Fatal("uniform range")
+	}
+	n := New(2000).RandNormal(mrand.New(mrand.NewSource(1)), 2)
+	mean := n.Sum() / 2000
+	variance := Dot(n.Data, n.Data)/2000 - mean*mean
+	if mean < -0.2 || mean > 0.2 || variance < 3.5 || variance > 4.5 {
+		t.Fatal("normal:", mean, variance)
+	}
+}
@@ -0,0 +1,149 @@
+package nn
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrShapeMismatch 输入、输出、权重或偏置的形状与网络不同，用errors.Is判断
+var ErrShapeMismatch = errors.New("shape mismatch")
+
+// ShapeError 形状不匹配的位置和大小
+type ShapeError struct {
+	Layer int    // 第几层权重，0为输入层到第一个隐藏层，-1为不属于某一层（输入、输出、层数）
+	What  string // 不匹配的部分，如"input"、"weight rows"、"bias"
+	Want  int
+	Got   int
+}
+
+func (e *ShapeError) Error() string {
+	if e.Layer < 0 {
+		return fmt.Sprintf("%s: %s: got %d, want %d", ErrShapeMismatch, e.What, e.Got, e.Want)
+	}
+	return fmt.Sprintf("%s: layer %d %s: got %d, want %d", ErrShapeMismatch, e.Layer, e.What, e.Got, e.Want)
+}
+
+// Is ...
+func (e *ShapeError) Is(target error) bool {
+	return target == ErrShapeMismatch
+}
+
+// 按InputNum、Layer和OutputNum的每层神经元数量
+func (o *NN) sizes() []int {
+	sizes := append([]int{o.InputNum}, o.Layer...)
+	return append(sizes, o.OutputNum)
+}
+
+// Validate 检查网络的结构、权重和偏置的形状以及每层的参数，不修改NN
+func (o *NN) Validate() error {
+	if o.InputNum <= 0 || o.OutputNum <= 0 {
+		return fmt.Errorf("InputNum %d and OutputNum %d must be positive", o.InputNum, o.OutputNum)
+	}
+	for k, v := range o.Layer {
+		if v <= 0 {
+			return fmt.Errorf("Layer[%d] is %d, must be positive", k, v)
+		}
+	}
+	if len(o.Activations) > len(o.Layer)+1 {
+		return &ShapeError{Layer: -1, What: "activations", Want: len(o.Layer) + 1, Got: len(o.Activations)}
+	}
+	if len(o.Initializers) > len(o.Layer)+1 {
+		return &ShapeError{Layer: -1, What: "initializers", Want: len(o.Layer) + 1, Got: len(o.Initializers)}
+	}
+	if len(o.Regularizations) > len(o.Layer)+1 {
+		return &ShapeError{Layer: -1, What: "regularizations", Want: len(o.Layer) + 1, Got: len(o.Regularizations)}
+	}
+	for k := 0; k <= len(o.Layer); k++ {
+		if err := o.regularization(k).check(); err != nil {
+			return fmt.Errorf("layer %d: %v", k, err)
+		}
+	}
+	if o.Weight != nil || o.Bias != nil {
+		if err := checkWeight(o.sizes(), o.Weight, o.Bias); err != nil {
+			return err
+		}
+	}
+	if o.EarlyStop != nil {
+		return o.EarlyStop.check()
+	}
+	return nil
+}
+
+// 权重和偏置的形状是否与每层的数量相同，bias为nil时不检查
+func checkWeight(sizes []int, weight [][][]Float, bias [][]Float) error {
+	if len(weight) != len(sizes)-1 {
+		return &ShapeError{Layer: -1, What: "weight layers", Want: len(sizes) - 1, Got: len(weight)}
+	}
+	if bias != nil && len(bias) != len(sizes)-1 {
+		return &ShapeError{Layer: -1, What: "bias layers", Want: len(sizes) - 1, Got: len(bias)}
+	}
+	for k, w := range weight {
+		if len(w) != sizes[k] {
+			return &ShapeError{Layer: k, What: "weight rows", Want: sizes[k], Got: len(w)}
+		}
+		for _, row := range w {
+			if len(row) != sizes[k+1] {
+				return &ShapeError{Layer: k, What: "weight columns", Want: sizes[k+1], Got: len(row)}
+			}
+		}
+		if bias != nil && len(bias[k]) != sizes[k+1] {
+			return &ShapeError{Layer: k, What: "bias", Want: sizes[k+1], Got: len(bias[k])}
+		}
+	}
+	return nil
+}
+
+// 权重和偏置已初始化并且形状与网络相同
+func (o *NN) checkShapes() error {
+	if err := checkWeight(o.sizes(), o.Weight, o.Bias); err != nil {
+		return err
+	}
+	if o.Bias == nil {
+		return &ShapeError{Layer: -1, What: "bias layers", Want: len(o.Layer) + 1, Got: 0}
+	}
+	return nil
+}
+
+func (o *NN) checkInput(input []Float) error {
+	if len(input) != o.InputNum {
+		return &ShapeError{Layer: -1, What: "input", Want: o.InputNum, Got: len(input)}
+	}
+	return nil
+}
+
+// 检查网络，没有权重或偏置时先Init
+func (o *NN) ready() error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if o.Weight == nil || o.Bias == nil {
+		return o.Init()
+	}
+	return nil
+}
+
+// Forward 同Right，不使用Normalization，没有权重或偏置时先Init，网络或输入的形状不对时返回错误
+func (o *NN) Forward(input []Float) ([]Float, error) {
+	if err := o.ready(); err != nil {
+		return nil, err
+	}
+	if err := o.checkInput(input); err != nil {
+		return nil, err
+	}
+	return o.Right(input), nil
+}
+
+// Backward 同Left，用最后一次Forward的结果修正权重，网络、输入或输出的形状不对时返回错误
+func (o *NN) Backward(input, output []Float) error {
+	if err := o.ready(); err != nil {
+		return err
+	}
+	if err := o.checkInput(input); err != nil {
+		return err
+	}
+	if len(output) != o.OutputNum {
+		return &ShapeError{Layer: -1, What: "output", Want: o.OutputNum, Got: len(output)}
+	}
+	o.Left(input, output)
+	return nil
+}
@@ -0,0 +1,108 @@
+package nn
+
+import (
+	"fmt"
+
+	"nn/tensor"
+)
+
+// Workspace 前向计算的缓存，不修改NN，每个goroutine使用自己的Workspace，
+// NN结构改变后需要重新创建
+type Workspace struct {
+	nn   *NN
+	z    [][]Float // 每层激活前的值
+	a    [][]Float // 每层激活后的值
+	d    [][]Float // 每层的残差
+	x    []Float   // Predict时归一化后的输入
+	grad *gradient // 并行训练时累加的梯度
+	err  error     // 创建时权重或偏置的形状不对，Predict返回这个错误
+
+	// 批量训练时每行为一个样本
+	bx         *tensor.Tensor   // 输入
+	bz, ba, bd []*tensor.Tensor // 每层激活前/后的值和残差
+}
+
+// NewWorkspace 权重或偏置没有初始化或形状不对时，Predict返回*ShapeError
+func (o *NN) NewWorkspace() *Workspace {
+	ws := &Workspace{nn: o}
+	if ws.err = o.checkShapes(); ws.err != nil {
+		return ws
+	}
+	ws.x = make([]Float, o.InputNum)
+	for _, v := range o.layerSizes() {
+		ws.z = append(ws.z, make([]Float, v))
+		ws.a = append(ws.a, make([]Float, v))
+		ws.d = append(ws.d, make([]Float, v))
+	}
+	return ws
+}
+
+// 是否与nn的结构一致
+func (o *Workspace) fits(nn *NN) bool {
+	if o == nil || o.nn != nn || len(o.z) == 0 || len(o.z) != len(nn.Weight) || len(o.z) != len(nn.Bias) || len(o.x) != nn.InputNum {
+		return false
+	}
+	in := nn.InputNum
+	for k, v := range nn.Bias {
+		if w := nn.Weight[k]; len(o.z[k]) != len(v) || len(w) != in || len(w[0]) != len(v) {
+			return false
+		}
+		in = len(v)
+	}
+	return true
+}
+
+// 批量训练n个样本的缓存
+func (o *Workspace) batch(n int) {
+	if o.bx != nil && o.bx.Shape[0] >= n && o.bx.Shape[1] == o.nn.InputNum {
+		return
+	}
+	o.bx = tensor.New(n, o.nn.InputNum)
+	o.bz, o.ba, o.bd = nil, nil, nil
+	for _, v := range o.nn.layerSizes() {
+		o.bz = append(o.bz, tensor.New(n, v))
+		o.ba = append(o.ba, tensor.New(n, v))
+		o.bd = append(o.bd, tensor.New(n, v))
+	}
+}
+
+// Predict 不分配内存，返回的结果在下一次调用时被覆盖
+func (o *Workspace) Predict(input []Float) ([]Float, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	if err := o.nn.checkInput(input); err != nil {
+		return nil, err
+	}
+	if !o.fits(o.nn) {
+		return nil, fmt.Errorf("workspace does not match the network")
+	}
+	n := o.nn.Normalization
+	if n == nil {
+		return o.nn.forward(input, o.z, o.a), nil
+	}
+	copy(o.x, input)
+	n.Input(o.x)
+	output := o.nn.forward(o.x, o.z, o.a)
+	n.Output(output)
+	return output, nil
+}
+
+// Predict 可以在多个goroutine中同时调用，不修改NN（包括Hidden、Output和input），
+// 有Normalization时先归一化输入，再把输出还原到原来的范围，
+// 频繁调用时可以每个goroutine使用一个Workspace避免分配内存，
+// 网络、权重、偏置或输入的形状不对时返回*ShapeError
+func (o *NN) Predict(input []Float) ([]Float, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	return o.NewWorkspace().Predict(input)
+}
+
+// Right/Left使用的缓存
+func (o *NN) workspace() *Workspace {
+	if !o.ws.fits(o) {
+		o.ws = o.NewWorkspace()
+	}
+	return o.ws
+}